@@ -55,6 +55,10 @@ type scansResponse struct {
 	Scans []scanHistory `json:"scans"`
 }
 
+type latencyResponse struct {
+	Latency map[string]time.Duration `json:"latency"`
+}
+
 type benchmarksResponse struct {
 	Benchmarks []hostdb.BenchmarkHistory `json:"benchmarks"`
 }
@@ -71,8 +75,9 @@ type nodeStatus struct {
 }
 
 type statusResponse struct {
-	Nodes   map[string]nodeStatus `json:"nodes"`
-	Version string                `json:"version"`
+	Nodes     map[string]nodeStatus `json:"nodes"`
+	Version   string                `json:"version"`
+	HostCache hostCacheStats        `json:"hostCache"`
 }
 
 type priceChange struct {
@@ -140,19 +145,20 @@ type nodeInteractions struct {
 }
 
 type portalHost struct {
-	ID           int                         `json:"id"`
-	Rank         int                         `json:"rank"`
-	PublicKey    types.PublicKey             `json:"publicKey"`
-	FirstSeen    time.Time                   `json:"firstSeen"`
-	KnownSince   uint64                      `json:"knownSince"`
-	NetAddress   string                      `json:"netaddress"`
-	Blocked      bool                        `json:"blocked"`
-	Interactions map[string]nodeInteractions `json:"interactions"`
-	IPNets       []string                    `json:"ipNets"`
-	LastIPChange time.Time                   `json:"lastIPChange"`
-	Score        scoreBreakdown              `json:"score"`
-	Settings     rhpv2.HostSettings          `json:"settings"`
-	PriceTable   rhpv3.HostPriceTable        `json:"priceTable"`
+	ID               int                         `json:"id"`
+	Rank             int                         `json:"rank"`
+	PublicKey        types.PublicKey             `json:"publicKey"`
+	FirstSeen        time.Time                   `json:"firstSeen"`
+	KnownSince       uint64                      `json:"knownSince"`
+	NetAddress       string                      `json:"netaddress"`
+	Blocked          bool                        `json:"blocked"`
+	Interactions     map[string]nodeInteractions `json:"interactions"`
+	IPNets           []string                    `json:"ipNets"`
+	LastIPChange     time.Time                   `json:"lastIPChange"`
+	Score            scoreBreakdown              `json:"score"`
+	Settings         rhpv2.HostSettings          `json:"settings"`
+	PriceTable       rhpv3.HostPriceTable        `json:"priceTable"`
+	SettingsWarnings []string                    `json:"settingsWarnings,omitempty"`
 	external.IPInfo
 }
 
@@ -179,33 +185,38 @@ const (
 )
 
 type portalAPI struct {
-	router   httprouter.Router
-	store    *jsonStore
-	db       *sql.DB
-	token    string
-	log      *zap.Logger
-	clients  map[string]*client.Client
-	mu       sync.RWMutex
-	cache    *responseCache
-	hosts    map[string]map[types.PublicKey]*portalHost
-	stopChan chan struct{}
-	averages map[string]map[string]networkAverages
-	nodes    map[string]nodeStatus
-	rl       *ratelimiter
+	router    httprouter.Router
+	store     *jsonStore
+	db        *sql.DB
+	token     string
+	log       *zap.Logger
+	clients   map[string]*client.Client
+	mu        sync.RWMutex
+	cache     *responseCache
+	hostCache *hostCache
+	hosts     map[string]map[types.PublicKey]*portalHost
+	stopChan  chan struct{}
+	averages  map[string]map[string]networkAverages
+	nodes     map[string]nodeStatus
+	rl        *ratelimiter
+
+	shadow   *shadowScorer
+	shadowMu sync.Mutex
 }
 
 func newAPI(s *jsonStore, db *sql.DB, token string, logger *zap.Logger, cache *responseCache) (*portalAPI, error) {
 	api := &portalAPI{
-		store:    s,
-		db:       db,
-		token:    token,
-		log:      logger,
-		clients:  make(map[string]*client.Client),
-		cache:    cache,
-		hosts:    make(map[string]map[types.PublicKey]*portalHost),
-		stopChan: make(chan struct{}),
-		averages: make(map[string]map[string]networkAverages),
-		nodes:    make(map[string]nodeStatus),
+		store:     s,
+		db:        db,
+		token:     token,
+		log:       logger,
+		clients:   make(map[string]*client.Client),
+		cache:     cache,
+		hostCache: newHostCache(hostCacheSize),
+		hosts:     make(map[string]map[types.PublicKey]*portalHost),
+		stopChan:  make(chan struct{}),
+		averages:  make(map[string]map[string]networkAverages),
+		nodes:     make(map[string]nodeStatus),
 	}
 
 	api.hosts["mainnet"] = make(map[types.PublicKey]*portalHost)
@@ -213,6 +224,10 @@ func newAPI(s *jsonStore, db *sql.DB, token string, logger *zap.Logger, cache *r
 
 	api.rl = newRatelimiter(api.stopChan)
 
+	if err := api.migrate(); err != nil {
+		return nil, err
+	}
+
 	err := api.load()
 	if err != nil {
 		return nil, err
@@ -347,12 +362,21 @@ func (api *portalAPI) buildHTTPRoutes() {
 	router.GET("/hosts/scans", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 		api.hostsScansHandler(w, req, ps)
 	})
+	router.GET("/hosts/latency", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.hostsLatencyHandler(w, req, ps)
+	})
 	router.GET("/hosts/benchmarks", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 		api.hostsBenchmarksHandler(w, req, ps)
 	})
 	router.GET("/hosts/changes", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 		api.hostsChangesHandler(w, req, ps)
 	})
+	router.GET("/hosts/region", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.hostsRegionHandler(w, req, ps)
+	})
+	router.GET("/hosts/diverse", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.hostsDiverseHandler(w, req, ps)
+	})
 
 	router.GET("/network/hosts", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 		api.networkHostsHandler(w, req, ps)
@@ -363,6 +387,9 @@ func (api *portalAPI) buildHTTPRoutes() {
 	router.GET("/network/countries", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 		api.networkCountriesHandler(w, req, ps)
 	})
+	router.GET("/network/graph", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.networkGraphHandler(w, req, ps)
+	})
 
 	router.GET("/service/status", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 		api.serviceStatusHandler(w, req, ps)
@@ -771,6 +798,43 @@ func (api *portalAPI) hostsScansHandler(w http.ResponseWriter, req *http.Request
 	writeJSON(w, scansResponse{Scans: scans})
 }
 
+func (api *portalAPI) hostsLatencyHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.rl.limitExceeded(getRemoteHost(req)) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	network := strings.ToLower(req.FormValue("network"))
+	if network == "" {
+		network = "mainnet"
+	}
+	if network != "mainnet" && network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+	host := req.FormValue("host")
+	if host == "" {
+		writeError(w, "host not provided", http.StatusBadRequest)
+		return
+	}
+	var pk types.PublicKey
+	err := pk.UnmarshalText([]byte(host))
+	if err != nil {
+		writeError(w, "invalid public key", http.StatusBadRequest)
+		return
+	}
+	latency, err := api.getRegionalLatency(network, pk)
+	if err != nil && errors.Is(err, errHostNotFound) {
+		writeError(w, "host not found", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		api.log.Error("couldn't get regional latency", zap.String("network", network), zap.Stringer("host", pk), zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, latencyResponse{Latency: latency})
+}
+
 func (api *portalAPI) hostsBenchmarksHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 	if api.rl.limitExceeded(getRemoteHost(req)) {
 		writeError(w, "too many requests", http.StatusTooManyRequests)
@@ -865,8 +929,9 @@ func (api *portalAPI) serviceStatusHandler(w http.ResponseWriter, req *http.Requ
 		return
 	}
 	writeJSON(w, statusResponse{
-		Version: build.ClientVersion,
-		Nodes:   api.nodes,
+		Version:   build.ClientVersion,
+		Nodes:     api.nodes,
+		HostCache: api.hostCache.stats(),
 	})
 }
 
@@ -958,6 +1023,76 @@ func (api *portalAPI) hostsChangesHandler(w http.ResponseWriter, req *http.Reque
 	writeJSON(w, priceChangeResponse{PriceChanges: pcs})
 }
 
+func (api *portalAPI) hostsRegionHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.rl.limitExceeded(getRemoteHost(req)) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	network := strings.ToLower(req.FormValue("network"))
+	if network == "" {
+		network = "mainnet"
+	}
+	if network != "mainnet" && network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+	var coords [4]float64
+	for i, name := range []string{"minlat", "minlon", "maxlat", "maxlon"} {
+		v, err := strconv.ParseFloat(req.FormValue(name), 64)
+		if err != nil {
+			writeError(w, "invalid "+name, http.StatusBadRequest)
+			return
+		}
+		coords[i] = v
+	}
+	hosts, err := api.getHostsInRegion(network, coords[0], coords[1], coords[2], coords[3])
+	if err != nil {
+		api.log.Error("couldn't get hosts in region", zap.String("network", network), zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, hostsResponse{Hosts: hosts, Total: len(hosts)})
+}
+
+func (api *portalAPI) hostsDiverseHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.rl.limitExceeded(getRemoteHost(req)) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	network := strings.ToLower(req.FormValue("network"))
+	if network == "" {
+		network = "mainnet"
+	}
+	if network != "mainnet" && network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+	n, err := strconv.ParseInt(req.FormValue("n"), 10, 64)
+	if err != nil || n <= 0 {
+		writeError(w, "invalid n", http.StatusBadRequest)
+		return
+	}
+	var countries map[types.PublicKey]string
+	if strings.ToLower(req.FormValue("country")) == "true" {
+		countries, err = api.getHostCountries(network)
+		if err != nil {
+			api.log.Error("couldn't get host countries", zap.String("network", network), zap.Error(err))
+			writeError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+	}
+	weights := defaultScoreWeights
+	if ws := req.FormValue("weights"); ws != "" {
+		weights, err = decodeScoreWeights([]byte(ws))
+		if err != nil {
+			writeError(w, "invalid weights", http.StatusBadRequest)
+			return
+		}
+	}
+	hosts := api.selectDiverse(network, int(n), weights, countries)
+	writeJSON(w, hostsResponse{Hosts: hosts, Total: len(hosts)})
+}
+
 func (api *portalAPI) networkAveragesHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 	if api.rl.limitExceeded(getRemoteHost(req)) {
 		writeError(w, "too many requests", http.StatusTooManyRequests)
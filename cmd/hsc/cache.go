@@ -1,6 +1,7 @@
 package main
 
 import (
+	"container/list"
 	"sync"
 	"time"
 
@@ -140,3 +141,100 @@ func (rc *responseCache) putHosts(network string, all bool, offset, limit int, q
 		modified: time.Now(),
 	})
 }
+
+// hostCacheSize is the number of hosts kept in the host cache. Zero
+// disables the cache.
+var hostCacheSize = 0
+
+// hostCacheKey identifies a host in the host cache.
+type hostCacheKey struct {
+	network string
+	pk      types.PublicKey
+}
+
+// hostCacheEntry is an element of the host cache.
+type hostCacheEntry struct {
+	key  hostCacheKey
+	host portalHost
+}
+
+// hostCacheStats contains the hit and miss counters of the host cache.
+type hostCacheStats struct {
+	Size   int    `json:"size"`
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+// hostCache is an LRU cache of the hosts returned by getHost.
+type hostCache struct {
+	size    int
+	ll      *list.List
+	entries map[hostCacheKey]*list.Element
+	hits    uint64
+	misses  uint64
+	mu      sync.Mutex
+}
+
+func newHostCache(size int) *hostCache {
+	return &hostCache{
+		size:    size,
+		ll:      list.New(),
+		entries: make(map[hostCacheKey]*list.Element),
+	}
+}
+
+func (hc *hostCache) get(network string, pk types.PublicKey) (portalHost, bool) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	if hc.size <= 0 {
+		return portalHost{}, false
+	}
+	e, ok := hc.entries[hostCacheKey{network, pk}]
+	if !ok {
+		hc.misses++
+		return portalHost{}, false
+	}
+	hc.hits++
+	hc.ll.MoveToFront(e)
+	return e.Value.(*hostCacheEntry).host, true
+}
+
+func (hc *hostCache) put(network string, pk types.PublicKey, host portalHost) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	if hc.size <= 0 {
+		return
+	}
+	key := hostCacheKey{network, pk}
+	if e, ok := hc.entries[key]; ok {
+		e.Value.(*hostCacheEntry).host = host
+		hc.ll.MoveToFront(e)
+		return
+	}
+	hc.entries[key] = hc.ll.PushFront(&hostCacheEntry{key: key, host: host})
+	for hc.ll.Len() > hc.size {
+		e := hc.ll.Back()
+		hc.ll.Remove(e)
+		delete(hc.entries, e.Value.(*hostCacheEntry).key)
+	}
+}
+
+func (hc *hostCache) invalidate(network string, pk types.PublicKey) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	key := hostCacheKey{network, pk}
+	if e, ok := hc.entries[key]; ok {
+		hc.ll.Remove(e)
+		delete(hc.entries, key)
+	}
+}
+
+func (hc *hostCache) stats() hostCacheStats {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	return hostCacheStats{
+		Size:   hc.ll.Len(),
+		Hits:   hc.hits,
+		Misses: hc.misses,
+	}
+}
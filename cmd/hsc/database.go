@@ -6,6 +6,7 @@ import (
 	"errors"
 	"math"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -151,13 +152,14 @@ func (api *portalAPI) insertUpdates(node string, updates hostdb.HostUpdates) err
 		INSERT INTO scans (
 			network,
 			node,
+			region,
 			public_key,
 			ran_at,
 			success,
 			latency,
 			error
 		)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
 	`)
 	if err != nil {
 		tx.Rollback()
@@ -238,6 +240,17 @@ func (api *portalAPI) insertUpdates(node string, updates hostdb.HostUpdates) err
 	}
 	defer updateScoreStmt.Close()
 
+	// The removed hosts go first, in case a host was announced again.
+	for _, removed := range updates.Removed {
+		for _, table := range []string{"locations", "price_changes", "benchmarks", "scans", "interactions", "hosts"} {
+			_, err := tx.Exec("DELETE FROM "+table+" WHERE network = ? AND public_key = ?", removed.Network, removed.PublicKey[:])
+			if err != nil {
+				tx.Rollback()
+				return utils.AddContext(err, "couldn't delete from "+table)
+			}
+		}
+	}
+
 	for _, host := range updates.Hosts {
 		var settings, pt bytes.Buffer
 		e := types.NewEncoder(&settings)
@@ -284,6 +297,7 @@ func (api *portalAPI) insertUpdates(node string, updates hostdb.HostUpdates) err
 		_, err := scanStmt.Exec(
 			scan.Network,
 			node,
+			scan.Region,
 			scan.PublicKey[:],
 			scan.Timestamp.Unix(),
 			scan.Success,
@@ -313,6 +327,10 @@ func (api *portalAPI) insertUpdates(node string, updates hostdb.HostUpdates) err
 	}
 
 	api.mu.Lock()
+	for _, removed := range updates.Removed {
+		delete(api.hosts[removed.Network], removed.PublicKey)
+		api.hostCache.invalidate(removed.Network, removed.PublicKey)
+	}
 	for _, h := range updates.Hosts {
 		var host *portalHost
 		var exists bool
@@ -362,6 +380,7 @@ func (api *portalAPI) insertUpdates(node string, updates hostdb.HostUpdates) err
 			host.IPNets = h.IPNets
 			host.LastIPChange = h.LastIPChange
 			host.Settings = h.Settings
+			host.SettingsWarnings = settingsWarnings(h.Settings)
 			host.PriceTable = h.PriceTable
 			interactions := host.Interactions[node]
 			interactions.Uptime = h.Uptime
@@ -378,17 +397,18 @@ func (api *portalAPI) insertUpdates(node string, updates hostdb.HostUpdates) err
 			host.Interactions[node] = interactions
 		} else {
 			host = &portalHost{
-				ID:           h.ID,
-				PublicKey:    h.PublicKey,
-				FirstSeen:    h.FirstSeen,
-				KnownSince:   h.KnownSince,
-				NetAddress:   h.NetAddress,
-				Blocked:      h.Blocked,
-				Interactions: make(map[string]nodeInteractions),
-				IPNets:       h.IPNets,
-				LastIPChange: h.LastIPChange,
-				Settings:     h.Settings,
-				PriceTable:   h.PriceTable,
+				ID:               h.ID,
+				PublicKey:        h.PublicKey,
+				FirstSeen:        h.FirstSeen,
+				KnownSince:       h.KnownSince,
+				NetAddress:       h.NetAddress,
+				Blocked:          h.Blocked,
+				Interactions:     make(map[string]nodeInteractions),
+				IPNets:           h.IPNets,
+				LastIPChange:     h.LastIPChange,
+				Settings:         h.Settings,
+				PriceTable:       h.PriceTable,
+				SettingsWarnings: settingsWarnings(h.Settings),
 			}
 			host.Interactions[node] = nodeInteractions{
 				Uptime:      h.Uptime,
@@ -419,6 +439,7 @@ func (api *portalAPI) insertUpdates(node string, updates hostdb.HostUpdates) err
 		}
 
 		host.Score = calculateGlobalScore(host)
+		api.shadowScore(host)
 		_, err := updateScoreStmt.Exec(
 			host.Score.PricesScore,
 			host.Score.StorageScore,
@@ -440,6 +461,7 @@ func (api *portalAPI) insertUpdates(node string, updates hostdb.HostUpdates) err
 			return utils.AddContext(err, "couldn't update score")
 		}
 		api.hosts[h.Network][h.PublicKey] = host
+		api.hostCache.invalidate(h.Network, h.PublicKey)
 	}
 
 	toUpdate := make(map[string]map[types.PublicKey]struct{})
@@ -489,6 +511,7 @@ func (api *portalAPI) insertUpdates(node string, updates hostdb.HostUpdates) err
 			}
 			interactions.Score = calculateScore(*host, node, interactions.ScanHistory, interactions.BenchmarkHistory)
 			host.Interactions[node] = interactions
+			api.hostCache.invalidate(network, pk)
 
 			_, err = interactionsStmt.Exec(
 				network,
@@ -520,6 +543,7 @@ func (api *portalAPI) insertUpdates(node string, updates hostdb.HostUpdates) err
 			}
 
 			host.Score = calculateGlobalScore(host)
+			api.shadowScore(host)
 			_, err := updateScoreStmt.Exec(
 				host.Score.PricesScore,
 				host.Score.StorageScore,
@@ -639,11 +663,22 @@ func (api *portalAPI) getHost(network string, pk types.PublicKey) (host portalHo
 	api.mu.RLock()
 	hosts := api.hosts[network]
 	h, exists := hosts[pk]
+	var rank int
+	if exists {
+		rank = h.Rank
+	}
 	api.mu.RUnlock()
 	if !exists {
 		return portalHost{}, errHostNotFound
 	}
 
+	// The rank changes with the scores of the other hosts, so it is
+	// not taken from the cache.
+	if cached, ok := api.hostCache.get(network, pk); ok {
+		cached.Rank = rank
+		return cached, nil
+	}
+
 	host = *h
 	info, lastFetched, err := api.getLocation(pk, network, host.NetAddress)
 	if err != nil {
@@ -666,6 +701,7 @@ func (api *portalAPI) getHost(network string, pk types.PublicKey) (host portalHo
 	}
 
 	host.IPInfo = info
+	api.hostCache.put(network, pk, host)
 	return
 }
 
@@ -910,6 +946,7 @@ func (api *portalAPI) getLocation(pk types.PublicKey, network, addr string) (inf
 
 // saveLocation saves the host's geolocation in the database.
 func (api *portalAPI) saveLocation(pk types.PublicKey, network string, info external.IPInfo) error {
+	lat, lon := parseCoordinates(info.Location)
 	_, err := api.db.Exec(`
 		INSERT INTO locations (
 			network,
@@ -923,9 +960,11 @@ func (api *portalAPI) saveLocation(pk types.PublicKey, network string, info exte
 			isp,
 			zip,
 			time_zone,
+			lat,
+			lon,
 			fetched_at
 		)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) AS new
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) AS new
 		ON DUPLICATE KEY UPDATE
 			ip = new.ip,
 			host_name = new.host_name,
@@ -936,6 +975,8 @@ func (api *portalAPI) saveLocation(pk types.PublicKey, network string, info exte
 			isp = new.isp,
 			zip = new.zip,
 			time_zone = new.time_zone,
+			lat = new.lat,
+			lon = new.lon,
 			fetched_at = new.fetched_at
 	`,
 		network,
@@ -949,12 +990,83 @@ func (api *portalAPI) saveLocation(pk types.PublicKey, network string, info exte
 		info.ISP,
 		info.ZIP,
 		info.TimeZone,
+		lat,
+		lon,
 		time.Now().Unix(),
 	)
 
 	return err
 }
 
+// parseCoordinates parses the location string of the form "lat,lon".
+func parseCoordinates(loc string) (lat, lon sql.NullFloat64) {
+	parts := strings.Split(loc, ",")
+	if len(parts) != 2 {
+		return
+	}
+	la, err1 := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	lo, err2 := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err1 != nil || err2 != nil {
+		return
+	}
+	return sql.NullFloat64{Float64: la, Valid: true}, sql.NullFloat64{Float64: lo, Valid: true}
+}
+
+// getHostsInRegion returns the hosts located within the given bounding box.
+// The hosts without the geolocation data are excluded.
+func (api *portalAPI) getHostsInRegion(network string, minLat, minLon, maxLat, maxLon float64) (hosts []portalHost, err error) {
+	rows, err := api.db.Query(`
+		SELECT
+			public_key,
+			ip,
+			host_name,
+			city,
+			region,
+			country,
+			loc,
+			isp,
+			zip,
+			time_zone
+		FROM locations
+		WHERE network = ?
+		AND lat BETWEEN ? AND ?
+		AND lon BETWEEN ? AND ?
+	`, network, minLat, maxLat, minLon, maxLon)
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't query locations")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		pk := make([]byte, 32)
+		var info external.IPInfo
+		if err := rows.Scan(
+			&pk,
+			&info.IP,
+			&info.HostName,
+			&info.City,
+			&info.Region,
+			&info.Country,
+			&info.Location,
+			&info.ISP,
+			&info.ZIP,
+			&info.TimeZone,
+		); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode location")
+		}
+		api.mu.RLock()
+		h, exists := api.hosts[network][types.PublicKey(pk)]
+		if exists {
+			host := *h
+			host.IPInfo = info
+			hosts = append(hosts, host)
+		}
+		api.mu.RUnlock()
+	}
+
+	return hosts, nil
+}
+
 // getScans returns the scan history according to the criteria provided.
 func (api *portalAPI) getScans(network, node string, pk types.PublicKey, all bool, from, to time.Time, limit int64) (scans []scanHistory, err error) {
 	f := int64(0)
@@ -1027,6 +1139,45 @@ func (api *portalAPI) getScans(network, node string, pk types.PublicKey, all boo
 	return
 }
 
+// getRegionalLatency returns the average latency of the successful scans
+// of the host, keyed by the region of the scanner that ran them. Since the
+// scanners run in different locations, this shows how fast the host is
+// for the renters in each of them. The scans received before the region
+// was recorded are keyed by the node.
+func (api *portalAPI) getRegionalLatency(network string, pk types.PublicKey) (map[string]time.Duration, error) {
+	api.mu.RLock()
+	_, ok := api.hosts[network][pk]
+	api.mu.RUnlock()
+	if !ok {
+		return nil, errHostNotFound
+	}
+
+	rows, err := api.db.Query(`
+		SELECT COALESCE(NULLIF(region, ''), node) AS scanner_region, AVG(latency)
+		FROM scans
+		WHERE network = ?
+		AND public_key = ?
+		AND success = TRUE
+		GROUP BY scanner_region
+	`, network, pk[:])
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't query scans")
+	}
+	defer rows.Close()
+
+	latencies := make(map[string]time.Duration)
+	for rows.Next() {
+		var region string
+		var latency float64
+		if err := rows.Scan(&region, &latency); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode latency")
+		}
+		latencies[region] = time.Duration(latency * float64(time.Millisecond))
+	}
+
+	return latencies, nil
+}
+
 // getBenchmarks returns the benchmark history according to the criteria provided.
 func (api *portalAPI) getBenchmarks(network, node string, pk types.PublicKey, all bool, from, to time.Time, limit int64) (benchmarks []hostdb.BenchmarkHistory, err error) {
 	f := int64(0)
@@ -1103,6 +1254,131 @@ func (api *portalAPI) getBenchmarks(network, node string, pk types.PublicKey, al
 	return
 }
 
+// portalColumnMigrations add the columns that are missing from a database
+// created with an older version of init_portal.sql.
+var portalColumnMigrations = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"locations", "lat", "DOUBLE"},
+	{"locations", "lon", "DOUBLE"},
+	{"scans", "region", "VARCHAR(64) NOT NULL DEFAULT ''"},
+}
+
+// migrate brings the tables up to date with init_portal.sql.
+func (api *portalAPI) migrate() error {
+	for _, m := range portalColumnMigrations {
+		var count int
+		err := api.db.QueryRow(`
+			SELECT COUNT(*)
+			FROM information_schema.columns
+			WHERE table_schema = DATABASE()
+			AND table_name = ?
+			AND column_name = ?
+		`, m.table, m.column).Scan(&count)
+		if err != nil {
+			return utils.AddContext(err, "couldn't query columns of "+m.table)
+		}
+		if count > 0 {
+			continue
+		}
+		_, err = api.db.Exec("ALTER TABLE " + m.table + " ADD COLUMN " + m.column + " " + m.definition)
+		if err != nil {
+			return utils.AddContext(err, "couldn't add column "+m.column+" to "+m.table)
+		}
+		api.log.Info("added column", zap.String("table", m.table), zap.String("column", m.column))
+	}
+
+	var count int
+	err := api.db.QueryRow(`
+		SELECT COUNT(*)
+		FROM information_schema.statistics
+		WHERE table_schema = DATABASE()
+		AND table_name = 'locations'
+		AND index_name = 'idx_locations_lat_lon'
+	`).Scan(&count)
+	if err != nil {
+		return utils.AddContext(err, "couldn't query indexes of locations")
+	}
+	if count == 0 {
+		if _, err := api.db.Exec("CREATE INDEX idx_locations_lat_lon ON locations (lat, lon)"); err != nil {
+			return utils.AddContext(err, "couldn't add index to locations")
+		}
+		api.log.Info("added index", zap.String("table", "locations"), zap.String("index", "idx_locations_lat_lon"))
+	}
+
+	return api.backfillCoordinates()
+}
+
+// backfillCoordinates fills the coordinates of the locations stored
+// before the lat and lon columns existed. Otherwise, these hosts would
+// be missing from the regional queries until their IP addresses changed.
+func (api *portalAPI) backfillCoordinates() error {
+	type location struct {
+		network string
+		pk      []byte
+		loc     string
+	}
+	rows, err := api.db.Query(`
+		SELECT network, public_key, loc
+		FROM locations
+		WHERE lat IS NULL
+		AND loc <> ''
+	`)
+	if err != nil {
+		return utils.AddContext(err, "couldn't query locations")
+	}
+	var locations []location
+	for rows.Next() {
+		l := location{pk: make([]byte, 32)}
+		if err := rows.Scan(&l.network, &l.pk, &l.loc); err != nil {
+			rows.Close()
+			return utils.AddContext(err, "couldn't decode location")
+		}
+		locations = append(locations, l)
+	}
+	rows.Close()
+	if len(locations) == 0 {
+		return nil
+	}
+
+	tx, err := api.db.Begin()
+	if err != nil {
+		return utils.AddContext(err, "couldn't start transaction")
+	}
+	stmt, err := tx.Prepare(`
+		UPDATE locations
+		SET lat = ?, lon = ?
+		WHERE network = ?
+		AND public_key = ?
+	`)
+	if err != nil {
+		tx.Rollback()
+		return utils.AddContext(err, "couldn't prepare statement")
+	}
+	defer stmt.Close()
+
+	var updated int
+	for _, l := range locations {
+		lat, lon := parseCoordinates(l.loc)
+		if !lat.Valid || !lon.Valid {
+			continue
+		}
+		if _, err := stmt.Exec(lat, lon, l.network, l.pk); err != nil {
+			tx.Rollback()
+			return utils.AddContext(err, "couldn't update location")
+		}
+		updated++
+	}
+	if err := tx.Commit(); err != nil {
+		return utils.AddContext(err, "couldn't commit transaction")
+	}
+
+	api.log.Info("backfilled coordinates", zap.Int("locations", updated))
+	return nil
+}
+
 // load loads the online hosts map from the database.
 func (api *portalAPI) load() error {
 	hostStmt, err := api.db.Prepare(`
@@ -1208,6 +1484,7 @@ func (api *portalAPI) load() error {
 				rows.Close()
 				return utils.AddContext(err, "couldn't decode host settings")
 			}
+			host.SettingsWarnings = settingsWarnings(host.Settings)
 		}
 		if len(pt) > 0 {
 			d := types.NewBufDecoder(pt)
@@ -1672,6 +1949,32 @@ func (api *portalAPI) updateAverages() {
 	}
 }
 
+// getHostCountries returns the countries of the hosts in the given
+// network.
+func (api *portalAPI) getHostCountries(network string) (map[types.PublicKey]string, error) {
+	rows, err := api.db.Query(`
+		SELECT public_key, country
+		FROM locations
+		WHERE network = ?
+	`, network)
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't query locations")
+	}
+	defer rows.Close()
+
+	countries := make(map[types.PublicKey]string)
+	for rows.Next() {
+		pk := make([]byte, 32)
+		var country string
+		if err := rows.Scan(&pk, &country); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode location")
+		}
+		countries[types.PublicKey(pk)] = country
+	}
+
+	return countries, nil
+}
+
 // getCountries returns the list of countries the hosts in the given
 // network reside in.
 func (api *portalAPI) getCountries(network string, all bool) (countries []string, _ error) {
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+// A graphNode is a host in the host graph.
+type graphNode struct {
+	ID         types.PublicKey `json:"id"`
+	NetAddress string          `json:"netaddress"`
+	Country    string          `json:"country"`
+	Location   string          `json:"location"`
+	Online     bool            `json:"online"`
+	Rank       int             `json:"rank"`
+	Score      float64         `json:"score"`
+}
+
+// A graphSubnet is a subnet in the host graph.
+type graphSubnet struct {
+	Subnet string `json:"subnet"`
+	Hosts  int    `json:"hosts"`
+}
+
+// A graphEdge connects a host to a subnet it belongs to.
+type graphEdge struct {
+	Host   types.PublicKey `json:"host"`
+	Subnet string          `json:"subnet"`
+}
+
+// A hostGraph is the graph of the hosts of a network and their subnets,
+// which can be used to visualize the topology of the network. The hosts
+// sharing a subnet are connected through the subnet node, so the size of
+// the graph grows linearly with the number of hosts.
+type hostGraph struct {
+	Nodes   []graphNode   `json:"nodes"`
+	Subnets []graphSubnet `json:"subnets"`
+	Edges   []graphEdge   `json:"edges"`
+}
+
+// getGraph returns the graph of the hosts of the network. The host nodes
+// are the hosts that are not blocked, and the edges connect them to their
+// subnets.
+func (api *portalAPI) getGraph(network string) (graph hostGraph, err error) {
+	type location struct{ country, loc string }
+	locations := make(map[types.PublicKey]location)
+	rows, err := api.db.Query(`
+		SELECT public_key, country, loc
+		FROM locations
+		WHERE network = ?
+	`, network)
+	if err != nil {
+		return hostGraph{}, utils.AddContext(err, "couldn't query locations")
+	}
+	for rows.Next() {
+		pk := make([]byte, 32)
+		var l location
+		if err := rows.Scan(&pk, &l.country, &l.loc); err != nil {
+			rows.Close()
+			return hostGraph{}, utils.AddContext(err, "couldn't decode location")
+		}
+		locations[types.PublicKey(pk)] = l
+	}
+	rows.Close()
+
+	subnets := make(map[string]int)
+	api.mu.RLock()
+	for pk, host := range api.hosts[network] {
+		if host.Blocked {
+			continue
+		}
+		graph.Nodes = append(graph.Nodes, graphNode{
+			ID:         pk,
+			NetAddress: host.NetAddress,
+			Country:    locations[pk].country,
+			Location:   locations[pk].loc,
+			Online:     isOnline(*host),
+			Rank:       host.Rank,
+			Score:      host.Score.TotalScore,
+		})
+		for _, ipNet := range host.IPNets {
+			if ipNet != "" {
+				subnets[ipNet]++
+				graph.Edges = append(graph.Edges, graphEdge{Host: pk, Subnet: ipNet})
+			}
+		}
+	}
+	api.mu.RUnlock()
+
+	for subnet, n := range subnets {
+		graph.Subnets = append(graph.Subnets, graphSubnet{Subnet: subnet, Hosts: n})
+	}
+
+	return graph, nil
+}
+
+func (api *portalAPI) networkGraphHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.rl.limitExceeded(getRemoteHost(req)) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	network := strings.ToLower(req.FormValue("network"))
+	if network == "" {
+		network = "mainnet"
+	}
+	if network != "mainnet" && network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+	graph, err := api.getGraph(network)
+	if err != nil {
+		api.log.Error("couldn't build host graph", zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, graph)
+}
@@ -17,6 +17,8 @@ import (
 	client "github.com/mike76-dev/hostscore/api"
 	"github.com/mike76-dev/hostscore/internal/build"
 	"github.com/mike76-dev/hostscore/persist"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
 	"golang.org/x/term"
 )
 
@@ -43,6 +45,11 @@ func main() {
 	dbName := flag.String("db-name", "", "name of the MySQL database")
 	dbUser := flag.String("db-user", "", "name of the database user")
 	portalPort := flag.String("portal", ":8080", "port number the portal server listens at")
+	flag.DurationVar(&latencyHalfLife, "latency-half-life", latencyHalfLife, "half-life of the latency measurements in the score")
+	flag.BoolVar(&excludeInvalidSettings, "exclude-invalid-settings", excludeInvalidSettings, "give the hosts with invalid settings a zero score")
+	flag.Float64Var(&shadowFraction, "shadow-fraction", shadowFraction, "fraction of the hosts scored with the shadow weights as well, 0 to disable")
+	flag.StringVar(&shadowWeightsFile, "shadow-weights", shadowWeightsFile, "JSON file with the score weights used for shadow scoring")
+	flag.IntVar(&hostCacheSize, "host-cache-size", hostCacheSize, "number of hosts kept in the host cache, 0 to disable")
 	flag.Parse()
 
 	err := os.MkdirAll(*dir, 0700)
@@ -111,6 +118,16 @@ func main() {
 	}
 	defer api.close()
 
+	if shadowFraction > 0 {
+		weights, err := loadScoreWeights(shadowWeightsFile)
+		if err != nil {
+			log.Fatalf("Could not load shadow score weights: %v\n", err)
+		}
+		api.SetShadowScorer(shadowFraction, weights, func(pk types.PublicKey, prod, shadow float64) {
+			logger.Info("shadow score", zap.Stringer("host", pk), zap.Float64("production", prod), zap.Float64("shadow", shadow))
+		})
+	}
+
 	for key, node := range s.nodes {
 		api.clients[key] = client.NewClient(node.Address, node.Password)
 	}
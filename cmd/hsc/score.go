@@ -1,8 +1,13 @@
 package main
 
 import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"math"
 	"math/big"
+	"os"
+	"sort"
 	"time"
 
 	"github.com/mike76-dev/hostscore/hostdb"
@@ -20,6 +25,24 @@ var (
 	contractPeriod   = uint64(144 * 30)                  // 1 month
 )
 
+// latencyHalfLife is the age at which the weight of a latency measurement
+// drops to one half.
+var latencyHalfLife = 24 * time.Hour
+
+// excludeInvalidSettings makes the hosts whose settings fail the sanity
+// checks score zero.
+var excludeInvalidSettings = false
+
+// shadowFraction is the fraction of the hosts that are scored with the
+// weights from shadowWeightsFile as well. Zero disables shadow scoring.
+var (
+	shadowFraction    = 0.0
+	shadowWeightsFile = ""
+)
+
+// jitterWindow is the period over which the latency jitter is calculated.
+const jitterWindow = 24 * time.Hour
+
 // calculateScore calculates the total host's score.
 func calculateScore(host portalHost, node string, scans []portalScan, benchmarks []hostdb.HostBenchmark) scoreBreakdown {
 	hostPeriodCost := hostPeriodCostForScore(host.Settings, host.PriceTable)
@@ -27,6 +50,9 @@ func calculateScore(host portalHost, node string, scans []portalScan, benchmarks
 	if !ok {
 		return scoreBreakdown{}
 	}
+	if excludeInvalidSettings && len(host.SettingsWarnings) > 0 {
+		return scoreBreakdown{}
+	}
 	sb := scoreBreakdown{
 		PricesScore:       priceAdjustmentScore(hostPeriodCost),
 		StorageScore:      storageRemainingScore(host.Settings),
@@ -54,6 +80,9 @@ func calculateScore(host portalHost, node string, scans []portalScan, benchmarks
 
 // calculateGlobalScore calculates the average score over all nodes.
 func calculateGlobalScore(host *portalHost) scoreBreakdown {
+	if excludeInvalidSettings && len(host.SettingsWarnings) > 0 {
+		return scoreBreakdown{}
+	}
 	hostPeriodCost := hostPeriodCostForScore(host.Settings, host.PriceTable)
 	sb := scoreBreakdown{
 		PricesScore:     priceAdjustmentScore(hostPeriodCost),
@@ -91,6 +120,139 @@ func calculateGlobalScore(host *portalHost) scoreBreakdown {
 	return sb
 }
 
+// settingsWarnings returns the problems found in the host settings.
+// A host that hasn't been scanned yet has no settings to check.
+func settingsWarnings(settings rhpv2.HostSettings) []string {
+	if (settings == rhpv2.HostSettings{}) {
+		return nil
+	}
+	return hostdb.ValidateSettings(settings)
+}
+
+// scoreWeights are the exponents applied to the score components when
+// the total score is calculated. A weight of 1 leaves the component as it
+// is, and a weight of 0 disables it.
+type scoreWeights struct {
+	Prices       float64 `json:"prices"`
+	Storage      float64 `json:"storage"`
+	Collateral   float64 `json:"collateral"`
+	Interactions float64 `json:"interactions"`
+	Uptime       float64 `json:"uptime"`
+	Age          float64 `json:"age"`
+	Version      float64 `json:"version"`
+	Latency      float64 `json:"latency"`
+	Benchmarks   float64 `json:"benchmarks"`
+	Contracts    float64 `json:"contracts"`
+}
+
+// defaultScoreWeights leave all score components as they are.
+var defaultScoreWeights = scoreWeights{
+	Prices:       1,
+	Storage:      1,
+	Collateral:   1,
+	Interactions: 1,
+	Uptime:       1,
+	Age:          1,
+	Version:      1,
+	Latency:      1,
+	Benchmarks:   1,
+	Contracts:    1,
+}
+
+// decodeScoreWeights decodes the score weights from a JSON object. The
+// weights missing from the object default to 1. Negative weights are
+// rejected, because they would favor the worse hosts.
+func decodeScoreWeights(b []byte) (scoreWeights, error) {
+	weights := defaultScoreWeights
+	if err := json.Unmarshal(b, &weights); err != nil {
+		return scoreWeights{}, err
+	}
+	for _, w := range []float64{
+		weights.Prices,
+		weights.Storage,
+		weights.Collateral,
+		weights.Interactions,
+		weights.Uptime,
+		weights.Age,
+		weights.Version,
+		weights.Latency,
+		weights.Benchmarks,
+		weights.Contracts,
+	} {
+		if w < 0 {
+			return scoreWeights{}, errors.New("negative score weight")
+		}
+	}
+	return weights, nil
+}
+
+// loadScoreWeights reads the score weights from a JSON file. The weights
+// missing from the file default to 1.
+func loadScoreWeights(path string) (scoreWeights, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return defaultScoreWeights, err
+	}
+	return decodeScoreWeights(b)
+}
+
+// weightedTotal calculates the total score using the given weights.
+func (sb scoreBreakdown) weightedTotal(w scoreWeights) float64 {
+	return math.Pow(sb.PricesScore, w.Prices) *
+		math.Pow(sb.StorageScore, w.Storage) *
+		math.Pow(sb.CollateralScore, w.Collateral) *
+		math.Pow(sb.InteractionsScore, w.Interactions) *
+		math.Pow(sb.UptimeScore, w.Uptime) *
+		math.Pow(sb.AgeScore, w.Age) *
+		math.Pow(sb.VersionScore, w.Version) *
+		math.Pow(sb.LatencyScore, w.Latency) *
+		math.Pow(sb.BenchmarksScore, w.Benchmarks) *
+		math.Pow(sb.ContractsScore, w.Contracts)
+}
+
+// shadowScorer calculates an alternative score for a sample of hosts,
+// so that a new scoring formula can be compared against the production
+// one without affecting the latter.
+type shadowScorer struct {
+	fraction float64
+	weights  scoreWeights
+	sink     func(pk types.PublicKey, prod, shadow float64)
+}
+
+// SetShadowScorer enables shadow scoring of the given fraction of hosts.
+// The production and the shadow scores of the sampled hosts are reported
+// to the sink. A nil sink disables shadow scoring.
+func (api *portalAPI) SetShadowScorer(fraction float64, weights scoreWeights, sink func(pk types.PublicKey, prod, shadow float64)) {
+	api.shadowMu.Lock()
+	defer api.shadowMu.Unlock()
+	if sink == nil || fraction <= 0 {
+		api.shadow = nil
+		return
+	}
+	api.shadow = &shadowScorer{
+		fraction: math.Min(fraction, 1),
+		weights:  weights,
+		sink:     sink,
+	}
+}
+
+// shadowScore reports the shadow score of the host if the host is sampled.
+// The sample is derived from the public key, so that the same hosts are
+// compared every time.
+func (api *portalAPI) shadowScore(host *portalHost) {
+	api.shadowMu.Lock()
+	ss := api.shadow
+	api.shadowMu.Unlock()
+	if ss == nil {
+		return
+	}
+	sample := float64(binary.LittleEndian.Uint64(host.PublicKey[:8])) / math.MaxUint64
+	if sample >= ss.fraction {
+		return
+	}
+	ss.sink(host.PublicKey, host.Score.TotalScore, host.Score.weightedTotal(ss.weights))
+}
+
 // priceAdjustmentScore computes a score between 0 and 1 for a host given its
 // price settings.
 //   - 0.5 is returned if the host's costs exactly match the settings.
@@ -378,19 +540,7 @@ func hostPeriodCostForScore(settings rhpv2.HostSettings, pt rhpv3.HostPriceTable
 
 // latencyScore calculates a score from the host's latency measurements.
 func latencyScore(history []portalScan) float64 {
-	var averageLatency float64
-	var totalLatency time.Duration
-	var totalSuccessfulScans int
-	for _, scan := range history {
-		if scan.Success {
-			totalLatency += scan.Latency
-			totalSuccessfulScans++
-		}
-	}
-
-	if totalSuccessfulScans > 0 {
-		averageLatency = float64(totalLatency.Milliseconds()) / float64(totalSuccessfulScans)
-	}
+	averageLatency := float64(weightedLatency(history, latencyHalfLife).Milliseconds())
 
 	// Catch an edge case.
 	if averageLatency == 0 {
@@ -402,12 +552,70 @@ func latencyScore(history []portalScan) float64 {
 		return 0
 	}
 
-	// If the latency is below 10ms, return 1.
+	// Unstable latency is penalized in proportion to its coefficient
+	// of variation.
+	penalty := 1.0
+	if jitter := latencyJitter(history, jitterWindow); jitter > 0 {
+		penalty = 1 / (1 + float64(jitter.Milliseconds())/averageLatency)
+	}
+
+	// If the latency is below 10ms, return 1, less the jitter penalty.
 	if averageLatency < 10 {
-		return 1
+		return penalty
+	}
+
+	return penalty * (1000 - averageLatency) / 1000
+}
+
+// latencyJitter calculates the standard deviation of the latencies of
+// the successful scans within the window.
+func latencyJitter(history []portalScan, window time.Duration) time.Duration {
+	var latencies []float64
+	for _, scan := range history {
+		if scan.Success && time.Since(scan.Timestamp) <= window {
+			latencies = append(latencies, float64(scan.Latency))
+		}
 	}
+	return time.Duration(stdDev(latencies))
+}
 
-	return (1000 - averageLatency) / 1000
+// stdDev calculates the standard deviation of the provided values.
+func stdDev(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	var mean float64
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	var variance float64
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(variance / float64(len(values)))
+}
+
+// weightedLatency calculates the exponentially weighted average latency
+// of the successful scans. The weight of a scan halves every halfLife, so
+// that the recent measurements matter more than the old ones.
+func weightedLatency(history []portalScan, halfLife time.Duration) time.Duration {
+	if halfLife <= 0 {
+		halfLife = latencyHalfLife
+	}
+	var sum, weights float64
+	for _, scan := range history {
+		if !scan.Success {
+			continue
+		}
+		w := math.Exp2(-float64(time.Since(scan.Timestamp)) / float64(halfLife))
+		sum += w * float64(scan.Latency)
+		weights += w
+	}
+	if weights == 0 {
+		return 0
+	}
+	return time.Duration(sum / weights)
 }
 
 // benchmarksScore calculates a score from the host's latest benchmarks.
@@ -456,3 +664,61 @@ func contractsScore(settings rhpv2.HostSettings) float64 {
 	}
 	return 0
 }
+
+// selectDiverse greedily selects up to n of the highest-scoring hosts
+// accepting contracts, picking at most one host per subnet. If countries
+// is not nil, at most one host per country is picked as well.
+func (api *portalAPI) selectDiverse(network string, n int, weights scoreWeights, countries map[types.PublicKey]string) []portalHost {
+	type candidate struct {
+		host  portalHost
+		score float64
+	}
+	var candidates []candidate
+	api.mu.RLock()
+	for _, host := range api.hosts[network] {
+		if host.Blocked || !host.Settings.AcceptingContracts {
+			continue
+		}
+		candidates = append(candidates, candidate{
+			host:  *host,
+			score: host.Score.weightedTotal(weights),
+		})
+	}
+	api.mu.RUnlock()
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].score == candidates[j].score {
+			return candidates[i].host.ID < candidates[j].host.ID
+		}
+		return candidates[i].score > candidates[j].score
+	})
+
+	var selected []portalHost
+	usedSubnets := make(map[string]bool)
+	usedCountries := make(map[string]bool)
+outer:
+	for _, c := range candidates {
+		if len(selected) >= n {
+			break
+		}
+		if c.score == 0 {
+			break
+		}
+		for _, subnet := range c.host.IPNets {
+			if usedSubnets[subnet] {
+				continue outer
+			}
+		}
+		country := countries[c.host.PublicKey]
+		if countries != nil {
+			if country == "" || usedCountries[country] {
+				continue
+			}
+			usedCountries[country] = true
+		}
+		for _, subnet := range c.host.IPNets {
+			usedSubnets[subnet] = true
+		}
+		selected = append(selected, c.host)
+	}
+	return selected
+}
@@ -6,6 +6,7 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"syscall"
 
 	"github.com/mike76-dev/hostscore/internal/build"
 	"github.com/mike76-dev/hostscore/persist"
@@ -36,9 +37,12 @@ func startDaemon(config *persist.HSDConfig, apiPassword, dbPassword, seed, seedZ
 	log.Println("api: Listening on", l.Addr())
 	go startWeb(l, n, apiPassword)
 	signalCh := make(chan os.Signal, 1)
-	signal.Notify(signalCh, os.Interrupt)
+	signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM)
 	<-signalCh
 	log.Println("Shutting down...")
+	if err := n.hdb.Flush(); err != nil {
+		log.Println("Unable to flush HostDB:", err)
+	}
 	stop()
 
 	return nil
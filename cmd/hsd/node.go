@@ -220,7 +220,7 @@ func newNode(config *persist.HSDConfig, dbPassword, seed, seedZen string) (*node
 	}
 
 	log.Println("Loading host database...")
-	hdb, errChan := hostdb.NewHostDB(mdb, config.Dir, cm, cmZen, s, sZen, w)
+	hdb, errChan := hostdb.NewHostDB(mdb, config.Dir, config.HostDB, cm, cmZen, s, sZen, w)
 	if err := utils.PeekErr(errChan); err != nil {
 		return nil, err
 	}
@@ -2,7 +2,9 @@ package hostdb
 
 import (
 	"errors"
+	"sync"
 
+	"github.com/mike76-dev/hostscore/internal/utils"
 	"go.sia.tech/core/types"
 )
 
@@ -20,6 +22,9 @@ var (
 	// errAnnUnrecognizedSignature is returned when the signature in a host
 	// announcement is not a type of signature that is recognized.
 	errAnnUnrecognizedSignature = errors.New("the signature provided in the host announcement is not recognized")
+
+	// lookupIPNets resolves the subnets of an announced address.
+	lookupIPNets = utils.LookupIPNets
 )
 
 // hostAnnouncement is an announcement by the host that appears in the
@@ -102,3 +107,85 @@ func decodeV2Announcement(at types.Attestation) (na string, pk types.PublicKey,
 
 	return string(at.Value), pk, nil
 }
+
+// An announcement is a decoded host announcement.
+type announcement struct {
+	netAddress string
+	publicKey  types.PublicKey
+
+	// The subnets the address resolves to, or the lookup error.
+	ipNets []string
+	err    error
+}
+
+// blockAnnouncements returns the host announcements of the block, both
+// the v1 and the v2 ones.
+func blockAnnouncements(b types.Block) []announcement {
+	var anns []announcement
+	for _, txn := range b.Transactions {
+		for _, ad := range txn.ArbitraryData {
+			addr, pk, err := decodeAnnouncement(ad)
+			if err != nil {
+				// Not a valid host announcement.
+				continue
+			}
+			anns = append(anns, announcement{netAddress: addr, publicKey: pk})
+		}
+	}
+	for _, txn := range b.V2Transactions() {
+		for _, at := range txn.Attestations {
+			addr, pk, err := decodeV2Announcement(at)
+			if err != nil {
+				// Not a valid host announcement.
+				continue
+			}
+			anns = append(anns, announcement{netAddress: addr, publicKey: pk})
+		}
+	}
+	return anns
+}
+
+// filterAnnouncements removes the announcements with an invalid or
+// a local address.
+func filterAnnouncements(anns []announcement) []announcement {
+	var filtered []announcement
+	for _, ann := range anns {
+		if err := utils.IsValid(ann.netAddress); err != nil {
+			// Invalid netaddress.
+			continue
+		}
+		if utils.IsLocal(ann.netAddress) {
+			// Local netaddress.
+			continue
+		}
+		filtered = append(filtered, ann)
+	}
+	return filtered
+}
+
+// resolveAnnouncements looks up the subnets of the announced addresses
+// using up to the given number of workers.
+func resolveAnnouncements(anns []announcement, workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(anns) {
+		workers = len(anns)
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				anns[j].ipNets, anns[j].err = lookupIPNets(anns[j].netAddress)
+			}
+		}()
+	}
+	for j := range anns {
+		jobs <- j
+	}
+	close(jobs)
+	wg.Wait()
+}
@@ -0,0 +1,71 @@
+package hostdb
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+// signedAnnouncement returns a v1 host announcement of the address
+// signed with the key.
+func signedAnnouncement(sk types.PrivateKey, addr string) []byte {
+	ha := hostAnnouncement{
+		Specifier:  prefixHostAnnouncement,
+		NetAddress: addr,
+		PublicKey:  sk.PublicKey().UnlockKey(),
+	}
+	h := types.NewHasher()
+	ha.EncodeTo(h.E)
+	sig := sk.SignHash(h.Sum())
+
+	var buf bytes.Buffer
+	e := types.NewEncoder(&buf)
+	ha.EncodeTo(e)
+	sig.EncodeTo(e)
+	e.Flush()
+	return buf.Bytes()
+}
+
+// TestManyAnnouncements checks that the announcements of a block are
+// resolved concurrently, so that a block with many announcements doesn't
+// hold up the chain updates.
+func TestManyAnnouncements(t *testing.T) {
+	const (
+		numAnnouncements = 200
+		lookupTime       = 20 * time.Millisecond
+		workers          = 8
+	)
+	defer func(lookup func(string) ([]string, error)) { lookupIPNets = lookup }(lookupIPNets)
+	lookupIPNets = func(addr string) ([]string, error) {
+		time.Sleep(lookupTime)
+		return []string{"1.2.3.0/24"}, nil
+	}
+
+	var b types.Block
+	for i := 0; i < numAnnouncements; i++ {
+		b.Transactions = append(b.Transactions, types.Transaction{
+			ArbitraryData: [][]byte{signedAnnouncement(types.GeneratePrivateKey(), fmt.Sprintf("host%d.example.com:9982", i))},
+		})
+	}
+
+	start := time.Now()
+	anns := filterAnnouncements(blockAnnouncements(b))
+	resolveAnnouncements(anns, workers)
+	elapsed := time.Since(start)
+
+	if len(anns) != numAnnouncements {
+		t.Fatalf("expected %d announcements, got %d", numAnnouncements, len(anns))
+	}
+	for _, ann := range anns {
+		if ann.err != nil || len(ann.ipNets) == 0 {
+			t.Fatalf("announcement of %s not resolved", ann.netAddress)
+		}
+	}
+	// Resolving the addresses one by one would take 4 seconds.
+	if limit := numAnnouncements * lookupTime / 2; elapsed > limit {
+		t.Fatalf("processing took %v, expected at most %v", elapsed, limit)
+	}
+}
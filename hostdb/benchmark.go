@@ -19,16 +19,96 @@ import (
 )
 
 const (
-	benchmarkInterval  = 2 * time.Hour
-	benchmarkBatchSize = 1 << 26 // 64 MiB
+	benchmarkInterval       = 2 * time.Hour
+	benchmarkBatchSize      = 1 << 26 // 64 MiB
+	defaultBenchmarkTimeout = 5 * time.Minute
 )
 
+// errBenchmarkTimeout is returned when a benchmark holds the benchmark
+// slot for too long.
+var errBenchmarkTimeout = errors.New("benchmark timeout exceeded")
+
+// errBenchmarkInterrupted is recorded with a partial benchmark cut short
+// by a shutdown.
+var errBenchmarkInterrupted = errors.New("benchmark interrupted")
+
+// errInsufficientBalance is returned when the benchmark contract cannot
+// pay for the benchmark.
+var errInsufficientBalance = errors.New("insufficient balance")
+
+// Benchmark error categories.
+const (
+	BenchmarkErrorUnreachable = "unreachable"
+	BenchmarkErrorPrivate     = "private"
+	BenchmarkErrorTimeout     = "timeout"
+	BenchmarkErrorGouging     = "gouging"
+	BenchmarkErrorContract    = "contract"
+	BenchmarkErrorPayment     = "payment"
+	BenchmarkErrorUpload      = "upload"
+	BenchmarkErrorDownload    = "download"
+	BenchmarkErrorInterrupted = "interrupted"
+	BenchmarkErrorOther       = "other"
+)
+
+// classifyBenchmarkError determines the category of a benchmark error.
+func classifyBenchmarkError(err error) string {
+	if err == nil {
+		return ""
+	}
+	msg := err.Error()
+	switch {
+	case errors.Is(err, errBenchmarkInterrupted):
+		return BenchmarkErrorInterrupted
+	case strings.Contains(msg, errPrivateAddress.Error()):
+		return BenchmarkErrorPrivate
+	case strings.Contains(msg, "deadline exceeded") || strings.Contains(msg, "timeout"):
+		return BenchmarkErrorTimeout
+	case strings.Contains(msg, "connection refused") ||
+		strings.Contains(msg, "no route to host") ||
+		strings.Contains(msg, "no such host") ||
+		strings.Contains(msg, "host settings unavailable"):
+		return BenchmarkErrorUnreachable
+	case strings.Contains(msg, "exceeds limit") ||
+		strings.Contains(msg, "is too low") ||
+		strings.Contains(msg, "not accepting contracts") ||
+		strings.Contains(msg, "overflow detected"):
+		return BenchmarkErrorGouging
+	case strings.Contains(msg, "unable to upload sector"):
+		return BenchmarkErrorUpload
+	case strings.Contains(msg, "unable to download sector"):
+		return BenchmarkErrorDownload
+	case errors.Is(err, errInsufficientBalance) ||
+		strings.Contains(msg, "account balance") ||
+		strings.Contains(msg, "fund account") ||
+		strings.Contains(msg, "price table"):
+		return BenchmarkErrorPayment
+	case strings.Contains(msg, "contract") ||
+		strings.Contains(msg, "revision") ||
+		strings.Contains(msg, "transaction set"):
+		return BenchmarkErrorContract
+	default:
+		return BenchmarkErrorOther
+	}
+}
+
 // benchmarkHost runs an up/download benchmark on a host.
 func (hdb *HostDB) benchmarkHost(host *HostDBEntry) {
 	if host.Network != "mainnet" && host.Network != "zen" {
 		panic("wrong host network")
 	}
 
+	// The host may have been queued again while it was being benchmarked.
+	// In this case, return immediately to free the slot.
+	if t := host.LastBenchmark.Timestamp; !t.IsZero() && time.Since(t) < benchmarkInterval {
+		return
+	}
+
+	// Contracts can't be formed safely before the network is synced,
+	// which is possible if ScanWhenUnsynced is set.
+	if !hdb.synced(host.Network) {
+		return
+	}
+
 	// Update historic interactions of the host if necessary.
 	hdb.updateHostHistoricInteractions(host)
 	limits := hdb.priceLimits
@@ -47,7 +127,24 @@ func (hdb *HostDB) benchmarkHost(host *HostDBEntry) {
 	var ul, dl float64
 	var ttfb time.Duration
 	var errMsg string
-	err := func() error {
+
+	// Keep track of the progress, so that a partial measurement can be
+	// derived if the benchmark gets interrupted.
+	var start time.Time
+	var uploaded, downloaded int
+	var partial bool
+	var concurrency int
+
+	err := func() (err error) {
+		// Limit the time the host can hold the slot.
+		slotCtx, slotCancel := context.WithTimeout(context.Background(), hdb.benchmarkTimeout)
+		defer slotCancel()
+		defer func() {
+			if err != nil && errors.Is(slotCtx.Err(), context.DeadlineExceeded) {
+				err = errBenchmarkTimeout
+			}
+		}()
+
 		// Do some checks first.
 		settings := host.Settings
 		if (settings == rhpv2.HostSettings{}) {
@@ -62,13 +159,20 @@ func (hdb *HostDB) benchmarkHost(host *HostDBEntry) {
 		if count > 5 {
 			return errors.New("too many hosts in the same subnet")
 		}
-		err := checkGouging(&settings, nil, limits)
+		if host.PrivateAddress && !hdb.scanPrivateAddresses {
+			return errPrivateAddress
+		}
+		err = checkGouging(&settings, nil, limits)
 		if err != nil {
 			return err
 		}
 
 		h, _, _ := net.SplitHostPort(host.NetAddress)
-		addr := net.JoinHostPort(h, host.Settings.SiaMuxPort)
+		port := host.Settings.SiaMuxPort
+		if len(host.ScanHistory) > 0 && host.ScanHistory[len(host.ScanHistory)-1].SiaMuxPort != "" {
+			port = host.ScanHistory[len(host.ScanHistory)-1].SiaMuxPort
+		}
+		addr := net.JoinHostPort(h, port)
 		numSectors := benchmarkBatchSize / rhpv2.SectorSize
 		var uploadCost, downloadCost types.Currency
 
@@ -77,7 +181,7 @@ func (hdb *HostDB) benchmarkHost(host *HostDBEntry) {
 			host.Revision.ValidRenterPayout().Cmp(benchmarkCost(host)) < 0 {
 			var rev rhpv2.ContractRevision
 			var txnSet []types.Transaction
-			formCtx, formCancel := context.WithTimeout(context.Background(), 2*time.Minute)
+			formCtx, formCancel := context.WithTimeout(slotCtx, 2*time.Minute)
 			defer formCancel()
 			go func() {
 				select {
@@ -124,7 +228,7 @@ func (hdb *HostDB) benchmarkHost(host *HostDBEntry) {
 			hdb.log.Info("successfully formed contract", zap.String("network", host.Network), zap.String("host", host.NetAddress), zap.Stringer("id", rev.Revision.ParentID))
 		} else {
 			// Fetch the latest revision.
-			revCtx, revCancel := context.WithTimeout(context.Background(), 30*time.Second)
+			revCtx, revCancel := context.WithTimeout(slotCtx, 30*time.Second)
 			defer revCancel()
 			go func() {
 				select {
@@ -146,29 +250,39 @@ func (hdb *HostDB) benchmarkHost(host *HostDBEntry) {
 			}
 		}
 
-		// Use the channel to prevent other threads from running benchmarks
-		// at the same time.
+		// Limit the number of the benchmarks measuring at the same time,
+		// because they compete for the bandwidth.
 		for {
 			hdb.mu.Lock()
-			if !hdb.benchmarking {
-				hdb.benchmarking = true
+			if len(hdb.benchmarkPeaks) < hdb.concurrentBenchmarks {
+				hdb.benchmarkPeaks[host.PublicKey] = 0
+				n := len(hdb.benchmarkPeaks)
+				for pk, peak := range hdb.benchmarkPeaks {
+					if peak < n {
+						hdb.benchmarkPeaks[pk] = n
+					}
+				}
 				hdb.mu.Unlock()
 				break
 			}
 			hdb.mu.Unlock()
 			select {
 			case <-hdb.tg.StopChan():
+				return context.Canceled
+			case <-slotCtx.Done():
+				return slotCtx.Err()
 			case <-time.After(time.Second):
 			}
 		}
 		defer func() {
 			hdb.mu.Lock()
-			hdb.benchmarking = false
+			concurrency = hdb.benchmarkPeaks[host.PublicKey]
+			delete(hdb.benchmarkPeaks, host.PublicKey)
 			hdb.mu.Unlock()
 		}()
 
 		// Fetch a valid price table.
-		ptCtx, ptCancel := context.WithTimeout(context.Background(), 30*time.Second)
+		ptCtx, ptCancel := context.WithTimeout(slotCtx, 30*time.Second)
 		defer ptCancel()
 		go func() {
 			select {
@@ -181,7 +295,7 @@ func (hdb *HostDB) benchmarkHost(host *HostDBEntry) {
 			pt, err := rhp.RPCPriceTable(ptCtx, t, func(pt rhpv3.HostPriceTable) (rhpv3.PaymentMethod, error) {
 				payment, ok := rhpv3.PayByContract(&host.Revision, pt.UpdatePriceTableCost, rhpv3.Account(key.PublicKey()), key)
 				if !ok {
-					return nil, errors.New("insufficient balance")
+					return nil, errInsufficientBalance
 				}
 				return &payment, nil
 			})
@@ -197,12 +311,13 @@ func (hdb *HostDB) benchmarkHost(host *HostDBEntry) {
 			if err != nil {
 				return err
 			}
+			host.BenchmarkRefresh = time.Now()
 
 			// Check the account balance.
 			payment, ok := rhpv3.PayByContract(&host.Revision, pt.AccountBalanceCost, rhpv3.Account(key.PublicKey()), key)
 			if !ok {
 				host.Revision = types.FileContractRevision{}
-				return errors.New("insufficient balance")
+				return errInsufficientBalance
 			}
 			balance, err := rhp.RPCAccountBalance(ptCtx, t, &payment, rhpv3.Account(key.PublicKey()), pt.UID)
 			if err != nil {
@@ -227,7 +342,7 @@ func (hdb *HostDB) benchmarkHost(host *HostDBEntry) {
 			payment, ok = rhpv3.PayByContract(&host.Revision, amount, rhpv3.Account{}, key)
 			if !ok {
 				host.Revision = types.FileContractRevision{}
-				return errors.New("insufficient balance")
+				return errInsufficientBalance
 			}
 			if err := rhp.RPCFundAccount(ptCtx, t, &payment, rhpv3.Account(key.PublicKey()), pt.UID); err != nil {
 				return utils.AddContext(err, "unable to fund account")
@@ -242,8 +357,7 @@ func (hdb *HostDB) benchmarkHost(host *HostDBEntry) {
 		// Run an upload benchmark.
 		var data [rhpv2.SectorSize]byte
 		roots := make([]types.Hash256, numSectors)
-		var start time.Time
-		upCtx, upCancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		upCtx, upCancel := context.WithTimeout(slotCtx, 5*time.Minute)
 		defer upCancel()
 		go func() {
 			select {
@@ -262,6 +376,7 @@ func (hdb *HostDB) benchmarkHost(host *HostDBEntry) {
 					return utils.AddContext(err, "unable to upload sector")
 				}
 				roots[i] = root
+				uploaded++
 			}
 			return nil
 		})
@@ -271,7 +386,7 @@ func (hdb *HostDB) benchmarkHost(host *HostDBEntry) {
 		ul = float64(benchmarkBatchSize) / time.Since(start).Seconds()
 
 		// Run a download benchmark.
-		dnCtx, dnCancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		dnCtx, dnCancel := context.WithTimeout(slotCtx, 5*time.Minute)
 		defer dnCancel()
 		go func() {
 			select {
@@ -292,6 +407,7 @@ func (hdb *HostDB) benchmarkHost(host *HostDBEntry) {
 				if i == 0 {
 					ttfb = time.Since(start)
 				}
+				downloaded++
 			}
 			if err != nil {
 				return err
@@ -302,33 +418,47 @@ func (hdb *HostDB) benchmarkHost(host *HostDBEntry) {
 		})
 		return err
 	}()
-	if err != nil && strings.Contains(err.Error(), "canceled") {
-		// Shutting down.
-		return
+	if errors.Is(err, context.Canceled) {
+		// Shutting down. Record what has been measured so far, if anything.
+		if uploaded == 0 {
+			return
+		}
+		partial = true
+		if ul == 0 {
+			ul = float64(uploaded*rhpv2.SectorSize) / time.Since(start).Seconds()
+		} else if downloaded > 0 {
+			dl = float64(downloaded*rhpv2.SectorSize) / time.Since(start).Seconds()
+		}
+		err = errBenchmarkInterrupted
 	}
-	if err != nil && strings.Contains(err.Error(), "insufficient balance") {
+	if errors.Is(err, errInsufficientBalance) {
 		// Not the host's fault.
-		hdb.mu.Lock()
-		delete(hdb.scanMap, host.PublicKey)
-		hdb.benchmarkThreads--
-		hdb.mu.Unlock()
 		return
 	}
-	if err == nil {
+	if partial {
+		// An interrupted benchmark says nothing about the host's
+		// reliability, so the interactions are left untouched. It is
+		// recorded as unsuccessful, because some of its speeds may be
+		// missing, which keeps it out of the averages.
+		errMsg = err.Error()
+	} else if err == nil {
 		success = true
-		hdb.IncrementSuccessfulInteractions(host)
+		hdb.IncrementSuccessfulInteractions(host, scanWeight)
 	} else {
 		errMsg = err.Error()
-		hdb.IncrementFailedInteractions(host)
+		hdb.IncrementFailedInteractions(host, scanWeight)
 	}
 
 	benchmark := HostBenchmark{
 		Timestamp:     timestamp,
 		Success:       success,
 		Error:         errMsg,
+		ErrorCategory: classifyBenchmarkError(err),
 		UploadSpeed:   ul,
 		DownloadSpeed: dl,
 		TTFB:          ttfb,
+		Partial:       partial,
+		Concurrency:   concurrency,
 	}
 	if host.Network == "zen" {
 		err = hdb.sZen.updateBenchmarks(host, benchmark)
@@ -338,12 +468,6 @@ func (hdb *HostDB) benchmarkHost(host *HostDBEntry) {
 	if err != nil {
 		hdb.log.Error("couldn't update benchmarks", zap.Error(err))
 	}
-
-	// Delete the host from scanMap.
-	hdb.mu.Lock()
-	delete(hdb.scanMap, host.PublicKey)
-	hdb.benchmarkThreads--
-	hdb.mu.Unlock()
 }
 
 // calculateBenchmarkInterval calculates a benchmark interval depending on
@@ -375,6 +499,16 @@ func (s *hostDBStore) calculateBenchmarkInterval(host *HostDBEntry) time.Duratio
 	return benchmarkInterval
 }
 
+// benchmarkDue returns true if the host passed its last scan and is due
+// for a benchmark.
+func (s *hostDBStore) benchmarkDue(host *HostDBEntry) bool {
+	if !isOnline(host) {
+		return false
+	}
+	t := host.LastBenchmark.Timestamp
+	return t.IsZero() || time.Since(t) >= s.calculateBenchmarkInterval(host)
+}
+
 // benchmarkCost estimates the cost of running a single benchmark.
 func benchmarkCost(host *HostDBEntry) types.Currency {
 	if (host.Settings == rhpv2.HostSettings{}) ||
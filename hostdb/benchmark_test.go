@@ -0,0 +1,68 @@
+package hostdb
+
+import (
+	"testing"
+	"time"
+)
+
+// TestSkipRecentBenchmark checks that a host benchmarked just now is not
+// benchmarked again and that its slot is released immediately.
+func TestSkipRecentBenchmark(t *testing.T) {
+	hdb := newTestHostDB()
+	defer hdb.tg.Stop()
+
+	host := randomHost()
+	host.LastBenchmark.Timestamp = time.Now()
+	hdb.mu.Lock()
+	hdb.benchmarkThreads++
+	hdb.scanMap[host.PublicKey] = true
+	hdb.inFlight[host.PublicKey] = struct{}{}
+	hdb.mu.Unlock()
+
+	start := time.Now()
+	hdb.runBenchmark(host, hdb.benchmarkHost)
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("skipping the benchmark took %v", elapsed)
+	}
+
+	hdb.mu.Lock()
+	defer hdb.mu.Unlock()
+	if hdb.benchmarkThreads != 0 {
+		t.Fatalf("expected no benchmark threads, got %d", hdb.benchmarkThreads)
+	}
+	if len(hdb.scanMap) != 0 || len(hdb.inFlight) != 0 {
+		t.Fatal("host not released after skipping the benchmark")
+	}
+}
+
+// TestOfflineHostNotBenchmarked checks that a host that was online but
+// failed its last scan is not queued for a benchmark.
+func TestOfflineHostNotBenchmarked(t *testing.T) {
+	hdb := newTestHostDB()
+	defer hdb.tg.Stop()
+
+	online := randomHost()
+	online.ScanInterval = time.Hour
+	online.ScanHistory = []HostScan{
+		{Timestamp: time.Now().Add(-20 * time.Minute), Success: true},
+		{Timestamp: time.Now().Add(-10 * time.Minute), Success: true},
+	}
+	offline := randomHost()
+	offline.ScanInterval = time.Hour
+	offline.ScanHistory = []HostScan{
+		{Timestamp: time.Now().Add(-20 * time.Minute), Success: true},
+		{Timestamp: time.Now().Add(-10 * time.Minute), Success: false},
+	}
+
+	hdb.queueScan(online)
+	hdb.queueScan(offline)
+
+	hdb.mu.Lock()
+	defer hdb.mu.Unlock()
+	if len(hdb.benchmarkList) != 1 || hdb.benchmarkList[0] != online {
+		t.Fatal("expected only the online host to be queued for a benchmark")
+	}
+	if _, exists := hdb.scanMap[offline.PublicKey]; exists {
+		t.Fatal("offline host queued")
+	}
+}
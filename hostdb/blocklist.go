@@ -0,0 +1,166 @@
+package hostdb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+)
+
+// ImportBlocklist reads a blocklist from r and blocks all hosts listed
+// there. Each line contains either a public key or a subnet in the CIDR
+// notation; empty lines and lines starting with '#' are skipped. All hosts
+// are blocked in one transaction, and they are removed from the scan
+// queues. The subnets and the keys of the hosts that are not known yet
+// are added to the blocked domains, so that they stay blocked after a
+// restart and the hosts announcing later are blocked too. The number of
+// the newly blocked hosts is returned.
+func (hdb *HostDB) ImportBlocklist(r io.Reader) (int, error) {
+	pks := make(map[types.PublicKey]struct{})
+	var subnets []*net.IPNet
+	var cidrs []string
+
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		entry := strings.TrimSpace(sc.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		if _, subnet, err := net.ParseCIDR(entry); err == nil {
+			subnets = append(subnets, subnet)
+			cidrs = append(cidrs, subnet.String())
+			continue
+		}
+		var pk types.PublicKey
+		if err := pk.UnmarshalText([]byte(entry)); err != nil {
+			return 0, fmt.Errorf("invalid entry on line %d: %s", line, entry)
+		}
+		pks[pk] = struct{}{}
+	}
+	if err := sc.Err(); err != nil {
+		return 0, utils.AddContext(err, "couldn't read blocklist")
+	}
+
+	total, err := hdb.blockAll(pks, subnets, cidrs)
+	if err != nil {
+		return 0, err
+	}
+
+	hdb.dequeueBlocked()
+	return total, nil
+}
+
+// blockAll blocks the hosts of both networks and saves the new blocked
+// domains in one transaction.
+func (hdb *HostDB) blockAll(pks map[types.PublicKey]struct{}, subnets []*net.IPNet, cidrs []string) (total int, err error) {
+	stores := hdb.stores("")
+	for _, s := range stores {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+
+	for _, s := range stores {
+		if s.tx == nil {
+			return 0, ErrStoreClosed
+		}
+	}
+
+	// Commit the pending writes first, so that the blocklist transaction
+	// doesn't wait for the rows they have locked.
+	defer func() {
+		for _, s := range stores {
+			if s.tx != nil {
+				continue
+			}
+			var beginErr error
+			s.tx, beginErr = s.db.Begin()
+			err = utils.ComposeErrors(err, utils.AddContext(beginErr, "couldn't begin transaction"))
+		}
+	}()
+	for _, s := range stores {
+		err := s.tx.Commit()
+		s.tx = nil
+		if err != nil {
+			return 0, utils.AddContext(err, "couldn't commit transaction")
+		}
+		s.lastCommitted = time.Now()
+	}
+
+	// Keep the keys of the hosts that are not known yet.
+	domains := make([]string, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !hdb.blockedDomains.has(cidr) {
+			domains = append(domains, cidr)
+		}
+	}
+	for pk := range pks {
+		var known bool
+		for _, s := range stores {
+			if _, exists := s.hosts[pk]; exists {
+				known = true
+				break
+			}
+		}
+		if !known && !hdb.blockedDomains.isKeyBlocked(pk) {
+			domains = append(domains, pk.String())
+		}
+	}
+
+	tx, err := hdb.s.db.Begin()
+	if err != nil {
+		return 0, utils.AddContext(err, "couldn't begin transaction")
+	}
+	for _, domain := range domains {
+		if _, err := tx.Exec("INSERT INTO hdb_domains (dom) VALUES (?)", domain); err != nil {
+			tx.Rollback()
+			return 0, utils.AddContext(err, "couldn't save blocked domain")
+		}
+	}
+	blocked := make(map[*hostDBStore][]*HostDBEntry)
+	for _, s := range stores {
+		hosts, err := s.blockHosts(tx, pks, subnets)
+		if err != nil {
+			tx.Rollback()
+			return 0, utils.AddContext(err, "couldn't block "+s.network+" hosts")
+		}
+		blocked[s] = hosts
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, utils.AddContext(err, "couldn't commit transaction")
+	}
+
+	hdb.blockedDomains.addDomains(domains)
+	for s, hosts := range blocked {
+		for _, host := range hosts {
+			host.Blocked = true
+			s.blockedHosts[host.PublicKey] = struct{}{}
+		}
+		total += len(hosts)
+	}
+	return total, nil
+}
+
+// dequeueBlocked removes the blocked hosts from the scan queues.
+func (hdb *HostDB) dequeueBlocked() {
+	filter := func(list []*HostDBEntry) []*HostDBEntry {
+		var kept []*HostDBEntry
+		for _, host := range list {
+			if host.Blocked {
+				delete(hdb.scanMap, host.PublicKey)
+				continue
+			}
+			kept = append(kept, host)
+		}
+		return kept
+	}
+
+	hdb.mu.Lock()
+	defer hdb.mu.Unlock()
+	hdb.scanList = filter(hdb.scanList)
+	hdb.benchmarkList = filter(hdb.benchmarkList)
+}
@@ -0,0 +1,68 @@
+package hostdb
+
+import (
+	"strings"
+	"testing"
+
+	"go.sia.tech/core/types"
+)
+
+// TestImportBlocklist checks that the listed hosts of both networks are
+// blocked, and that the keys of the unknown hosts are kept, so that they
+// are blocked when they announce.
+func TestImportBlocklist(t *testing.T) {
+	hdb := newTestHostDB()
+	s := newTestStore(t, hdb)
+
+	listed, unlisted, inSubnet := randomHost(), randomHost(), randomHost()
+	inSubnet.IPNets = []string{"203.0.113.0/24"}
+	zen := randomHost()
+	zen.Network = "zen"
+	for _, host := range []*HostDBEntry{listed, unlisted, inSubnet} {
+		s.hosts[host.PublicKey] = host
+	}
+	hdb.sZen.hosts[zen.PublicKey] = zen
+	unknown := types.GeneratePrivateKey().PublicKey()
+
+	list := strings.Join([]string{
+		"# shared blocklist",
+		listed.PublicKey.String(),
+		zen.PublicKey.String(),
+		unknown.String(),
+		"",
+		"203.0.113.0/24",
+	}, "\n")
+	n, err := hdb.ImportBlocklist(strings.NewReader(list))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("expected 3 blocked hosts, got %d", n)
+	}
+	for _, host := range []*HostDBEntry{listed, inSubnet, zen} {
+		if !host.Blocked {
+			t.Errorf("host %v not blocked", host.PublicKey)
+		}
+	}
+	if unlisted.Blocked {
+		t.Error("unlisted host blocked")
+	}
+	if !hdb.blockedDomains.isKeyBlocked(unknown) {
+		t.Error("unknown key not kept")
+	}
+	if !recorder.executed(t.Name(), "INSERT INTO hdb_domains") {
+		t.Error("blocked domains not saved")
+	}
+	if hdb.s.tx == nil || hdb.sZen.tx == nil {
+		t.Error("store transactions not reopened")
+	}
+
+	// An unknown host is blocked as soon as it is added.
+	host := &HostDBEntry{Network: "mainnet", PublicKey: unknown}
+	if err := s.update(host); err != nil {
+		t.Fatal(err)
+	}
+	if !host.Blocked {
+		t.Error("announced host not blocked")
+	}
+}
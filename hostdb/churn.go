@@ -0,0 +1,103 @@
+package hostdb
+
+import (
+	"sort"
+	"time"
+
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+const (
+	// minChurnChanges is the number of address changes within the window
+	// that make a host count as churning.
+	minChurnChanges = 2
+)
+
+// A ChurnCluster is a group of churning hosts that have been using the
+// same subnets, which suggests a single operator rotating the addresses.
+type ChurnCluster struct {
+	Network string            `json:"network"`
+	Hosts   []types.PublicKey `json:"hosts"`
+	Subnets []string          `json:"subnets"`
+	Changes int               `json:"changes"`
+}
+
+// AddressChurnReport returns the clusters of hosts that changed their
+// addresses repeatedly within the window while sharing subnets.
+func (hdb *HostDB) AddressChurnReport(window time.Duration) []ChurnCluster {
+	var clusters []ChurnCluster
+	for _, s := range hdb.stores("") {
+		changes, err := s.getIPChanges(time.Now().Add(-window))
+		if err != nil {
+			hdb.log.Error("couldn't get address changes", zap.String("network", s.network), zap.Error(err))
+			continue
+		}
+		clusters = append(clusters, churnClusters(s.network, changes)...)
+	}
+	return clusters
+}
+
+// churnClusters groups the churning hosts by the subnets they used.
+func churnClusters(network string, changes []ipChange) []ChurnCluster {
+	counts := make(map[types.PublicKey]int)
+	for _, c := range changes {
+		counts[c.publicKey]++
+	}
+
+	// Join the churning hosts that used a common subnet.
+	parent := make(map[types.PublicKey]types.PublicKey)
+	var find func(pk types.PublicKey) types.PublicKey
+	find = func(pk types.PublicKey) types.PublicKey {
+		if p, exists := parent[pk]; exists && p != pk {
+			parent[pk] = find(p)
+			return parent[pk]
+		}
+		parent[pk] = pk
+		return pk
+	}
+	subnetOwner := make(map[string]types.PublicKey)
+	for _, c := range changes {
+		if counts[c.publicKey] < minChurnChanges {
+			continue
+		}
+		for _, ipNet := range c.ipNets {
+			if ipNet == "" {
+				continue
+			}
+			if owner, exists := subnetOwner[ipNet]; exists {
+				parent[find(c.publicKey)] = find(owner)
+			} else {
+				subnetOwner[ipNet] = c.publicKey
+				find(c.publicKey)
+			}
+		}
+	}
+
+	groups := make(map[types.PublicKey]*ChurnCluster)
+	for pk := range parent {
+		root := find(pk)
+		cluster, exists := groups[root]
+		if !exists {
+			cluster = &ChurnCluster{Network: network}
+			groups[root] = cluster
+		}
+		cluster.Hosts = append(cluster.Hosts, pk)
+		cluster.Changes += counts[pk]
+	}
+	for ipNet, owner := range subnetOwner {
+		groups[find(owner)].Subnets = append(groups[find(owner)].Subnets, ipNet)
+	}
+
+	var clusters []ChurnCluster
+	for _, cluster := range groups {
+		if len(cluster.Hosts) < 2 {
+			continue
+		}
+		sort.Strings(cluster.Subnets)
+		clusters = append(clusters, *cluster)
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].Changes > clusters[j].Changes })
+
+	return clusters
+}
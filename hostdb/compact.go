@@ -0,0 +1,265 @@
+package hostdb
+
+import (
+	"math"
+	"time"
+
+	"github.com/mike76-dev/hostscore/internal/utils"
+	rhpv2 "go.sia.tech/core/rhp/v2"
+	"go.sia.tech/core/types"
+)
+
+// ScanRollup summarizes the scans of a host within a single day.
+type ScanRollup struct {
+	Day        time.Time          `json:"day"`
+	Successes  int                `json:"successes"`
+	Failures   int                `json:"failures"`
+	MinLatency time.Duration      `json:"minLatency"`
+	AvgLatency time.Duration      `json:"avgLatency"`
+	MaxLatency time.Duration      `json:"maxLatency"`
+	Settings   rhpv2.HostSettings `json:"settings"`
+}
+
+// rollup is a ScanRollup under construction.
+type rollup struct {
+	day       int64
+	successes int
+	failures  int
+	min, max  float64
+	sum       float64
+	settings  []byte
+
+	// pending is set if some scans of the day haven't been fetched by
+	// the portal yet. Such days are not compacted.
+	pending bool
+}
+
+// compactBatchSize is the number of hosts compacted under a single lock.
+const compactBatchSize = 100
+
+// compactHistory replaces the scans that were run before the given time
+// with daily rollups. The time is rounded down to the start of the day,
+// so that the scans of a day are always compacted together.
+func (s *hostDBStore) compactHistory(before time.Time) error {
+	cutoff := before.Unix() - before.Unix()%86400
+
+	s.mu.Lock()
+	keys := make([]types.PublicKey, 0, len(s.hosts))
+	for pk := range s.hosts {
+		keys = append(keys, pk)
+	}
+	s.mu.Unlock()
+
+	for len(keys) > 0 {
+		n := min(compactBatchSize, len(keys))
+		if err := s.compactHosts(keys[:n], cutoff); err != nil {
+			return err
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
+
+// compactHosts compacts the scans of the given hosts that were run before
+// the cutoff and commits the changes.
+func (s *hostDBStore) compactHosts(keys []types.PublicKey, cutoff int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tx == nil {
+		return ErrStoreClosed
+	}
+
+	for _, pk := range keys {
+		if _, exists := s.hosts[pk]; !exists {
+			// The host was removed in the meantime.
+			continue
+		}
+		rollups, err := s.rollupScans(pk, cutoff)
+		if err != nil {
+			return err
+		}
+		for _, r := range rollups {
+			if r.pending {
+				continue
+			}
+			if err := s.insertRollup(pk, r); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := s.tx.Commit(); err != nil {
+		return utils.AddContext(err, "couldn't commit transaction")
+	}
+
+	var err error
+	s.tx, err = s.db.Begin()
+	return err
+}
+
+// rollupScans summarizes the host's scans that were run before the cutoff
+// by day.
+// NOTE: a lock must be acquired before calling rollupScans.
+func (s *hostDBStore) rollupScans(pk types.PublicKey, cutoff int64) ([]*rollup, error) {
+	rows, err := s.tx.Query(`
+		SELECT ran_at, success, latency, settings, modified, fetched
+		FROM hdb_scans_`+s.network+`
+		WHERE public_key = ?
+		AND ran_at < ?
+		ORDER BY ran_at ASC
+	`, pk[:], cutoff)
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't query scans")
+	}
+	defer rows.Close()
+
+	var rollups []*rollup
+	var current *rollup
+	for rows.Next() {
+		var settings []byte
+		var ranAt, modified, fetched int64
+		var success bool
+		var latency float64
+		if err := rows.Scan(&ranAt, &success, &latency, &settings, &modified, &fetched); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode scan")
+		}
+		day := ranAt - ranAt%86400
+		if current == nil || current.day != day {
+			current = &rollup{day: day, min: math.Inf(1)}
+			rollups = append(rollups, current)
+		}
+		if modified > fetched {
+			current.pending = true
+		}
+		if !success {
+			current.failures++
+			continue
+		}
+		current.successes++
+		current.sum += latency
+		current.min = math.Min(current.min, latency)
+		current.max = math.Max(current.max, latency)
+		if len(settings) > 0 {
+			current.settings = settings
+		}
+	}
+
+	return rollups, rows.Err()
+}
+
+// insertRollup replaces the host's scans of the day with the rollup.
+// NOTE: a lock must be acquired before calling insertRollup.
+func (s *hostDBStore) insertRollup(pk types.PublicKey, r *rollup) error {
+	var avg float64
+	if r.successes > 0 {
+		avg = r.sum / float64(r.successes)
+	} else {
+		r.min = 0
+	}
+	_, err := s.tx.Exec(`
+		INSERT INTO hdb_rollups_`+s.network+` (
+			public_key,
+			day,
+			successes,
+			failures,
+			min_latency,
+			avg_latency,
+			max_latency,
+			settings
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		pk[:],
+		r.day,
+		r.successes,
+		r.failures,
+		r.min,
+		avg,
+		r.max,
+		r.settings,
+	)
+	if err != nil {
+		return utils.AddContext(err, "couldn't insert rollup")
+	}
+
+	_, err = s.tx.Exec(`
+		DELETE FROM hdb_scans_`+s.network+`
+		WHERE public_key = ?
+		AND ran_at >= ?
+		AND ran_at < ?
+		AND modified <= fetched
+	`, pk[:], r.day, r.day+86400)
+	if err != nil {
+		return utils.AddContext(err, "couldn't delete compacted scans")
+	}
+
+	return nil
+}
+
+// getRollups returns the daily rollups of the host, oldest first.
+func (s *hostDBStore) getRollups(pk types.PublicKey) ([]ScanRollup, error) {
+	rows, err := s.db.Query(`
+		SELECT day, successes, failures, min_latency, avg_latency, max_latency, settings
+		FROM hdb_rollups_`+s.network+`
+		WHERE public_key = ?
+		ORDER BY day ASC
+	`, pk[:])
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't query rollups")
+	}
+	defer rows.Close()
+
+	var rollups []ScanRollup
+	for rows.Next() {
+		var day int64
+		var successes, failures int
+		var minLatency, avgLatency, maxLatency float64
+		var settings []byte
+		if err := rows.Scan(&day, &successes, &failures, &minLatency, &avgLatency, &maxLatency, &settings); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode rollup")
+		}
+		var scan HostScan
+		if err := decodeScan(&scan, settings, nil); err != nil {
+			return nil, err
+		}
+		rollups = append(rollups, ScanRollup{
+			Day:        time.Unix(day, 0),
+			Successes:  successes,
+			Failures:   failures,
+			MinLatency: time.Duration(minLatency) * time.Millisecond,
+			AvgLatency: time.Duration(avgLatency) * time.Millisecond,
+			MaxLatency: time.Duration(maxLatency) * time.Millisecond,
+			Settings:   scan.Settings,
+		})
+	}
+
+	return rollups, nil
+}
+
+// CompactHistory replaces the scans of all hosts that were run before the
+// given day with daily rollups. The rollups keep the number of successful
+// and failed scans, the latency statistics, and the settings of the last
+// successful scan of each day. The days with scans that the portal hasn't
+// fetched yet are left as they are.
+func (hdb *HostDB) CompactHistory(before time.Time) error {
+	if err := hdb.tg.Add(); err != nil {
+		return err
+	}
+	defer hdb.tg.Done()
+
+	for _, s := range hdb.stores("") {
+		if err := s.compactHistory(before); err != nil {
+			return utils.AddContext(err, "couldn't compact "+s.network+" history")
+		}
+	}
+	return nil
+}
+
+// ScanRollups returns the daily rollups of the host's compacted scans.
+func (hdb *HostDB) ScanRollups(pk types.PublicKey) ([]ScanRollup, error) {
+	s, exists := hdb.hostStore(pk)
+	if !exists {
+		return nil, ErrHostNotFound
+	}
+	return s.getRollups(pk)
+}
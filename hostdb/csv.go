@@ -0,0 +1,136 @@
+package hostdb
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+// csvRow contains the summary of a host written by ExportCSV.
+type csvRow struct {
+	pk           types.PublicKey
+	network      string
+	netAddress   string
+	uptime       time.Duration
+	downtime     time.Duration
+	storagePrice float64
+	host         HostDBEntry
+}
+
+// csvFields maps the field names accepted by ExportCSV to their values.
+var csvFields = map[string]func(r csvRow) string{
+	"pubkey":     func(r csvRow) string { return r.pk.String() },
+	"network":    func(r csvRow) string { return r.network },
+	"netaddress": func(r csvRow) string { return r.netAddress },
+	"online":     func(r csvRow) string { return strconv.FormatBool(isOnline(&r.host)) },
+	"latency": func(r csvRow) string {
+		if !isOnline(&r.host) {
+			return ""
+		}
+		latency := r.host.ScanHistory[len(r.host.ScanHistory)-1].Latency
+		return strconv.FormatInt(latency.Milliseconds(), 10)
+	},
+	"uptime": func(r csvRow) string {
+		total := r.uptime + r.downtime
+		if total == 0 {
+			return ""
+		}
+		return strconv.FormatFloat(float64(r.uptime)/float64(total)*100, 'f', 2, 64)
+	},
+	"storageprice":  func(r csvRow) string { return strconv.FormatFloat(r.storagePrice, 'f', -1, 64) },
+	"uploadspeed":   func(r csvRow) string { return strconv.FormatFloat(r.host.LastBenchmark.UploadSpeed, 'f', 0, 64) },
+	"downloadspeed": func(r csvRow) string { return strconv.FormatFloat(r.host.LastBenchmark.DownloadSpeed, 'f', 0, 64) },
+}
+
+// ExportCSV writes a summary of the hosts of both networks to w in the CSV
+// format. The first row contains the names of the requested fields, which
+// can be any of: pubkey, network, netaddress, online, latency (ms), uptime
+// (%), storageprice (SC/TB/month), and uploadspeed and downloadspeed
+// (B/s). Blocked hosts are skipped.
+func (hdb *HostDB) ExportCSV(w io.Writer, fields []string) error {
+	if len(fields) == 0 {
+		return fmt.Errorf("no fields requested")
+	}
+	for _, field := range fields {
+		if _, ok := csvFields[field]; !ok {
+			return fmt.Errorf("unknown field: %s", field)
+		}
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(fields); err != nil {
+		return err
+	}
+	for _, s := range hdb.stores("") {
+		if err := s.exportCSV(cw, fields); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// exportCSV writes the summary of the store's hosts.
+func (s *hostDBStore) exportCSV(cw *csv.Writer, fields []string) error {
+	if s.tx == nil {
+		s.log.Error("there is no transaction", zap.String("network", s.network))
+		return ErrStoreClosed
+	}
+
+	// The hosts are collected first, so that the lock is not held while
+	// writing.
+	s.mu.Lock()
+	rows, err := s.tx.Query(`
+		SELECT public_key, net_address, uptime, downtime, storage_price
+		FROM hdb_hosts_` + s.network + `
+		WHERE blocked = FALSE
+		ORDER BY id ASC
+	`)
+	if err != nil {
+		s.mu.Unlock()
+		return utils.AddContext(err, "couldn't query hosts")
+	}
+
+	var csvRows []csvRow
+	for rows.Next() {
+		pk := make([]byte, 32)
+		var ut, dt int64
+		r := csvRow{network: s.network}
+		if err := rows.Scan(&pk, &r.netAddress, &ut, &dt, &r.storagePrice); err != nil {
+			rows.Close()
+			s.mu.Unlock()
+			return utils.AddContext(err, "couldn't decode host")
+		}
+		r.pk = types.PublicKey(pk)
+		r.uptime = time.Duration(ut) * time.Second
+		r.downtime = time.Duration(dt) * time.Second
+		if host, exists := s.hosts[r.pk]; exists {
+			r.host = *host
+		}
+		csvRows = append(csvRows, r)
+	}
+	err = rows.Err()
+	rows.Close()
+	s.mu.Unlock()
+	if err != nil {
+		return utils.AddContext(err, "couldn't query hosts")
+	}
+
+	record := make([]string, len(fields))
+	for _, r := range csvRows {
+		for i, field := range fields {
+			record[i] = csvFields[field](r)
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,204 @@
+package hostdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+const (
+	// defaultExportParallelism is the number of shards read concurrently
+	// if no parallelism is specified.
+	defaultExportParallelism = 4
+
+	// exportShardBuffer is the number of records a shard can read ahead
+	// before it has to wait for the writer.
+	exportShardBuffer = 16
+)
+
+// ExportOptions controls how the database is exported.
+type ExportOptions struct {
+	// Parallelism is the number of key range shards read concurrently.
+	Parallelism int
+
+	// Unordered lets the records be written as soon as they are read
+	// instead of in the order of the public keys.
+	Unordered bool
+}
+
+// An ExportRecord contains a host with its full scan and benchmark history.
+type ExportRecord struct {
+	Host       HostDBEntry     `json:"host"`
+	Scans      []HostScan      `json:"scans"`
+	Benchmarks []HostBenchmark `json:"benchmarks"`
+}
+
+// A HostExport contains a single host with its full history and the
+// notes on it.
+type HostExport struct {
+	ExportRecord
+	Notes []HostNote `json:"notes"`
+}
+
+// exportHost is a host scheduled for export.
+type exportHost struct {
+	s    *hostDBStore
+	host HostDBEntry
+}
+
+// Export writes the hosts of both networks, each with its full history,
+// to w as a stream of JSON records. The hosts are split into shards by
+// their public keys, which are read in parallel.
+func (hdb *HostDB) Export(w io.Writer, opts ExportOptions) error {
+	parallelism := opts.Parallelism
+	if parallelism <= 0 {
+		parallelism = defaultExportParallelism
+	}
+	if parallelism > math.MaxUint8+1 {
+		parallelism = math.MaxUint8 + 1
+	}
+
+	// Split the hosts into shards by the first byte of the public key.
+	shards := make([][]exportHost, parallelism)
+	for _, s := range []*hostDBStore{hdb.s, hdb.sZen} {
+		s.mu.Lock()
+		for pk, host := range s.hosts {
+			i := int(pk[0]) * parallelism / (math.MaxUint8 + 1)
+			shards[i] = append(shards[i], exportHost{s: s, host: *host})
+		}
+		s.mu.Unlock()
+	}
+	for _, shard := range shards {
+		sort.Slice(shard, func(i, j int) bool {
+			a, b := shard[i].host, shard[j].host
+			if a.PublicKey == b.PublicKey {
+				return a.Network < b.Network
+			}
+			return bytes.Compare(a.PublicKey[:], b.PublicKey[:]) < 0
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var once sync.Once
+	var exportErr error
+	fail := func(err error) {
+		once.Do(func() {
+			exportErr = err
+			cancel()
+		})
+	}
+
+	// Read the shards. If the order doesn't matter, all shards share
+	// the same channel.
+	chans := make([]chan ExportRecord, parallelism)
+	var shared chan ExportRecord
+	if opts.Unordered {
+		shared = make(chan ExportRecord, exportShardBuffer*parallelism)
+	}
+	var wg sync.WaitGroup
+	for i := range shards {
+		if opts.Unordered {
+			chans[i] = shared
+		} else {
+			chans[i] = make(chan ExportRecord, exportShardBuffer)
+		}
+		wg.Add(1)
+		go func(shard []exportHost, out chan<- ExportRecord) {
+			defer wg.Done()
+			if !opts.Unordered {
+				defer close(out)
+			}
+			for _, eh := range shard {
+				record, err := eh.s.exportRecord(eh.host)
+				if err != nil {
+					fail(err)
+					return
+				}
+				select {
+				case out <- record:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(shards[i], chans[i])
+	}
+	if opts.Unordered {
+		go func() {
+			wg.Wait()
+			close(shared)
+		}()
+		chans = []chan ExportRecord{shared}
+	}
+
+	// Write the records.
+	enc := json.NewEncoder(w)
+	for _, ch := range chans {
+		for record := range ch {
+			if err := enc.Encode(record); err != nil {
+				fail(err)
+				break
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	// Stop the readers that might still be running.
+	cancel()
+	wg.Wait()
+
+	return exportErr
+}
+
+// exportRecord loads the full history of the host.
+func (s *hostDBStore) exportRecord(host HostDBEntry) (ExportRecord, error) {
+	scans, err := s.getScans(host.PublicKey, time.Unix(0, 0), time.Now())
+	if err != nil {
+		return ExportRecord{}, err
+	}
+	benchmarks, err := s.getBenchmarks(host.PublicKey, time.Unix(0, 0), time.Now())
+	if err != nil {
+		return ExportRecord{}, err
+	}
+	return ExportRecord{
+		Host:       host,
+		Scans:      scans,
+		Benchmarks: benchmarks,
+	}, nil
+}
+
+// ExportHost writes the host with its full scan and benchmark history,
+// its interactions, and the notes on it to w as a single indented JSON
+// document.
+func (hdb *HostDB) ExportHost(pk types.PublicKey, w io.Writer) error {
+	s, exists := hdb.hostStore(pk)
+	if !exists {
+		return ErrHostNotFound
+	}
+	host, exists := s.hostEntry(pk)
+	if !exists {
+		return ErrHostNotFound
+	}
+	record, err := s.exportRecord(host)
+	if err != nil {
+		return err
+	}
+	notes, err := s.getNotes(pk)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(HostExport{ExportRecord: record, Notes: notes})
+}
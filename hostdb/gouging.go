@@ -11,6 +11,10 @@ import (
 
 const (
 	contractDuration = 7 * 144 // 7 days
+
+	// pricingTolerance is the maximum relative difference between
+	// the RHP2 and the RHP3 prices of a host.
+	pricingTolerance = 0.01
 )
 
 // hostDBPriceLimits are meant to protect the node from malicious hosts
@@ -231,3 +235,34 @@ func sectorUploadCostRHPv3(pt rhpv3.HostPriceTable) (types.Currency, bool) {
 	}
 	return total, false
 }
+
+// PricingConsistent returns false if the storage or bandwidth prices in
+// the host's settings and in its price table differ by more than
+// pricingTolerance. If either of them is missing, the host is considered
+// consistent.
+func (h HostDBEntry) PricingConsistent() bool {
+	if (h.Settings == rhpv2.HostSettings{}) || (h.PriceTable == rhpv3.HostPriceTable{}) {
+		return true
+	}
+	return pricesAgree(h.Settings.StoragePrice, h.PriceTable.WriteStoreCost) &&
+		pricesAgree(h.Settings.UploadBandwidthPrice, h.PriceTable.UploadBandwidthCost) &&
+		pricesAgree(h.Settings.DownloadBandwidthPrice, h.PriceTable.DownloadBandwidthCost)
+}
+
+// pricesAgree returns true if the prices are within pricingTolerance
+// of each other.
+func pricesAgree(a, b types.Currency) bool {
+	if a.Equals(b) {
+		return true
+	}
+	fa, fb := a.Siacoins(), b.Siacoins()
+	max := fa
+	if fb > max {
+		max = fb
+	}
+	diff := fa - fb
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= pricingTolerance*max
+}
@@ -2,9 +2,13 @@ package hostdb
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"net"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
@@ -22,28 +26,69 @@ import (
 	"lukechampine.com/frand"
 )
 
+var (
+	// ErrHostNotFound is returned when the requested host is not
+	// in the database.
+	ErrHostNotFound = errors.New("host not found")
+
+	// ErrStoreClosed is returned when the database has already been
+	// closed.
+	ErrStoreClosed = errors.New("database is closed")
+
+	// ErrNotSynced is returned when the requested information depends
+	// on the node being synced to the network.
+	ErrNotSynced = errors.New("node is not synced")
+
+	// ErrScanTimeout is returned when a scan didn't complete in time.
+	ErrScanTimeout = errors.New("scan timed out")
+
+	// ErrIncompleteHistory is returned when some of the host's scans
+	// have already been pruned or compacted, so the scan history can't
+	// be used to rebuild the uptime.
+	ErrIncompleteHistory = errors.New("scan history is incomplete")
+)
+
+const (
+	// defaultRegion is the scanner region used if none is configured.
+	defaultRegion = "default"
+
+	// defaultScanRetention is the number of days the scans are kept
+	// for if not configured otherwise.
+	defaultScanRetention = 7
+
+	// defaultAnnouncementWorkers is the number of the announced addresses
+	// of a block resolved concurrently if not configured otherwise.
+	defaultAnnouncementWorkers = 8
+)
+
 // A HostDBEntry represents one host entry in the HostDB. It
 // aggregates the host's external settings and metrics with its public key.
 type HostDBEntry struct {
-	ID            int                        `json:"id"`
-	Network       string                     `json:"network"`
-	PublicKey     types.PublicKey            `json:"publicKey"`
-	FirstSeen     time.Time                  `json:"firstSeen"`
-	KnownSince    uint64                     `json:"knownSince"`
-	NetAddress    string                     `json:"netaddress"`
-	Blocked       bool                       `json:"blocked"`
-	Uptime        time.Duration              `json:"uptime"`
-	Downtime      time.Duration              `json:"downtime"`
-	ScanHistory   []HostScan                 `json:"scanHistory"`
-	LastBenchmark HostBenchmark              `json:"lastBenchmark"`
-	Interactions  HostInteractions           `json:"interactions"`
-	LastSeen      time.Time                  `json:"lastSeen"`
-	IPNets        []string                   `json:"ipNets"`
-	ActiveHosts   int                        `json:"activeHosts"`
-	LastIPChange  time.Time                  `json:"lastIPChange"`
-	Revision      types.FileContractRevision `json:"-"`
-	Settings      rhpv2.HostSettings         `json:"settings"`
-	PriceTable    rhpv3.HostPriceTable       `json:"priceTable"`
+	ID               int                        `json:"id"`
+	Network          string                     `json:"network"`
+	PublicKey        types.PublicKey            `json:"publicKey"`
+	FirstSeen        time.Time                  `json:"firstSeen"`
+	KnownSince       uint64                     `json:"knownSince"`
+	NetAddress       string                     `json:"netaddress"`
+	Blocked          bool                       `json:"blocked"`
+	Uptime           time.Duration              `json:"uptime"`
+	Downtime         time.Duration              `json:"downtime"`
+	ScanHistory      []HostScan                 `json:"scanHistory"`
+	LastBenchmark    HostBenchmark              `json:"lastBenchmark"`
+	Interactions     HostInteractions           `json:"interactions"`
+	LastSeen         time.Time                  `json:"lastSeen"`
+	IPNets           []string                   `json:"ipNets"`
+	ActiveHosts      int                        `json:"activeHosts"`
+	LastIPChange     time.Time                  `json:"lastIPChange"`
+	ScanInterval     time.Duration              `json:"scanInterval"`
+	Priority         int                        `json:"priority"`
+	Favorite         bool                       `json:"favorite"`
+	PreviousKeys     []types.PublicKey          `json:"previousKeys,omitempty"`
+	PrivateAddress   bool                       `json:"privateAddress"`
+	Revision         types.FileContractRevision `json:"-"`
+	Settings         rhpv2.HostSettings         `json:"settings"`
+	PriceTable       rhpv3.HostPriceTable       `json:"priceTable"`
+	BenchmarkRefresh time.Time                  `json:"benchmarkRefresh"`
 	external.IPInfo
 }
 
@@ -65,6 +110,27 @@ type HostScan struct {
 	Error      string               `json:"error"`
 	Settings   rhpv2.HostSettings   `json:"settings"`
 	PriceTable rhpv3.HostPriceTable `json:"priceTable"`
+
+	// RHP3TTFB is the time it took the host to respond to the RHP3
+	// handshake, and PriceTableFetch is the total time it took to
+	// obtain the price table.
+	RHP3TTFB        time.Duration `json:"rhp3TTFB"`
+	PriceTableFetch time.Duration `json:"priceTableFetch"`
+
+	SettingsWarnings []string `json:"settingsWarnings"`
+
+	// SiaMuxPort is the port the RHP3 connection succeeded on. It differs
+	// from the advertised one if a fallback port had to be used.
+	SiaMuxPort string `json:"siamuxPort"`
+
+	// Signature is the scanner's signature of the scan, if the scans
+	// are signed. See VerifyScan.
+	Signature types.Signature `json:"signature"`
+
+	// EverOnline tells if the host had a successful scan before a failed
+	// one. A failing host that was never online probably has a bad
+	// announcement rather than being down.
+	EverOnline bool `json:"everOnline"`
 }
 
 // ScanHistory combines the scan history with the host's public key.
@@ -73,6 +139,7 @@ type ScanHistory struct {
 	PublicKey types.PublicKey `json:"publicKey"`
 	Network   string          `json:"network"`
 	Node      string          `json:"node"`
+	Region    string          `json:"region,omitempty"`
 }
 
 // A HostBenchmark contains the information measured during a host benchmark.
@@ -81,9 +148,12 @@ type HostBenchmark struct {
 	Timestamp     time.Time     `json:"timestamp"`
 	Success       bool          `json:"success"`
 	Error         string        `json:"error"`
+	ErrorCategory string        `json:"errorCategory"`
 	UploadSpeed   float64       `json:"uploadSpeed"`
 	DownloadSpeed float64       `json:"downloadSpeed"`
 	TTFB          time.Duration `json:"ttfb"`
+	Partial       bool          `json:"partial"`
+	Concurrency   int           `json:"concurrency"`
 }
 
 // BenchmarkHistory combines the benchmark history with the host's public key.
@@ -103,6 +173,14 @@ type HostUpdates struct {
 	Hosts      []HostDBEntry      `json:"hosts"`
 	Scans      []ScanHistory      `json:"scans"`
 	Benchmarks []BenchmarkHistory `json:"benchmarks"`
+	Removed    []RemovedHost      `json:"removed"`
+}
+
+// A RemovedHost is a host that was removed from the database, e.g.
+// because its announcement was reverted.
+type RemovedHost struct {
+	PublicKey types.PublicKey `json:"publicKey"`
+	Network   string          `json:"network"`
 }
 
 // The HostDB is a database of hosts.
@@ -118,18 +196,57 @@ type HostDB struct {
 	w              *walletutil.Wallet
 	log            *zap.Logger
 	closeFn        func()
+	region         string
+	dir            string
 
 	tg siasync.ThreadGroup
 	mu sync.Mutex
 
-	benchmarking     bool
+	benchmarkPeaks   map[types.PublicKey]int
 	scanList         []*HostDBEntry
+	scanQueue        chan *HostDBEntry
 	benchmarkList    []*HostDBEntry
 	scanMap          map[types.PublicKey]bool
+	inFlight         map[types.PublicKey]struct{}
+	lastScanSubnet   string
+	subnetSwitches   uint64
+	dnsLookups       uint64
+	slowDNSLookups   uint64
 	scanThreads      int
 	benchmarkThreads int
 	priceLimits      hostDBPriceLimits
 	blockedDomains   *blockedDomains
+	ignoredSubnets   ignoredSubnets
+	publisher        ResultPublisher
+	publishQueue     chan ScanEvent
+	droppedEvents    uint64
+
+	scanValidator func(HostScan) error
+
+	scanCompletions      []completion
+	benchmarkCompletions []completion
+	medianPrices         MedianPrices
+	priceDist            priceDistribution
+	priceIndexHistory    []PriceIndexSample
+
+	initialScanLatencies   []time.Duration
+	scanTimeout            time.Duration
+	scanTimeoutPercentile  float64
+	scanTimeoutMultiplier  float64
+	scanPrivateAddresses   bool
+	ipChangeScanMultiplier float64
+	benchmarkTimeout       time.Duration
+	syncWaitInterval       time.Duration
+	reuseAddress           bool
+	scanWhenUnsynced       bool
+	signScans              bool
+	groupBySubnet          bool
+	announcementWorkers    int
+	concurrentBenchmarks   int
+
+	// Retention periods of the scans in days.
+	successfulScanRetention int
+	failedScanRetention     int
 }
 
 // RecentUpdates returns a list of the most recent updates since the last retrieval.
@@ -150,15 +267,172 @@ func (hdb *HostDB) RecentUpdates() (HostUpdates, error) {
 	updates.Hosts = append(updates.Hosts, updatesZen.Hosts...)
 	updates.Scans = append(updates.Scans, updatesZen.Scans...)
 	updates.Benchmarks = append(updates.Benchmarks, updatesZen.Benchmarks...)
+	updates.Removed = append(updates.Removed, updatesZen.Removed...)
 
 	return updates, nil
 }
 
+// RawScan returns the raw encodings of the settings and the price table
+// recorded during the specified scan of the host.
+func (hdb *HostDB) RawScan(pk types.PublicKey, timestamp time.Time) (settings []byte, priceTable []byte, err error) {
+	s, exists := hdb.hostStore(pk)
+	if !exists {
+		return nil, nil, ErrHostNotFound
+	}
+	return s.getRawScan(pk, timestamp)
+}
+
+// LatencySample is the average latency of a host within a time bucket.
+// Gap is set if there were no successful scans within the bucket.
+type LatencySample struct {
+	Timestamp time.Time     `json:"timestamp"`
+	Latency   time.Duration `json:"latency"`
+	Gap       bool          `json:"gap"`
+}
+
+// maxLatencyBuckets is the maximum number of samples returned by
+// LatencyTrend.
+const maxLatencyBuckets = 1000
+
+// LatencyTrend returns the average latency of the host per time bucket
+// within the given time range. The buckets are at least one second long.
+// If the range would be split into more than maxLatencyBuckets buckets,
+// the buckets are made longer.
+func (hdb *HostDB) LatencyTrend(pk types.PublicKey, bucket time.Duration, from, to time.Time) []LatencySample {
+	if !from.Before(to) {
+		return nil
+	}
+	span := to.Sub(from)
+	if minBucket := span / maxLatencyBuckets; bucket < minBucket {
+		bucket = minBucket
+	}
+	if bucket < time.Second {
+		bucket = time.Second
+	}
+	bucket = bucket.Truncate(time.Second)
+	if (span+bucket-1)/bucket > maxLatencyBuckets {
+		bucket += time.Second
+	}
+	s, exists := hdb.hostStore(pk)
+	if !exists {
+		return nil
+	}
+	latencies, err := s.getLatencyTrend(pk, bucket, from, to)
+	if err != nil {
+		hdb.log.Error("couldn't get latency trend", zap.String("network", s.network), zap.Error(err))
+		return nil
+	}
+
+	samples := make([]LatencySample, 0, (span+bucket-1)/bucket)
+	for i, t := int64(0), from; t.Before(to); i, t = i+1, t.Add(bucket) {
+		latency, ok := latencies[i]
+		samples = append(samples, LatencySample{
+			Timestamp: t,
+			Latency:   latency,
+			Gap:       !ok,
+		})
+	}
+	return samples
+}
+
+// IgnoreSubnet makes HostDB ignore the hosts from the given subnet.
+// Their announcements are dropped, and they are not scanned anymore.
+func (hdb *HostDB) IgnoreSubnet(cidr string) {
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		hdb.log.Error("couldn't parse subnet", zap.String("subnet", cidr), zap.Error(err))
+		return
+	}
+	hdb.ignoredSubnets.add(subnet)
+}
+
+// IgnoredSubnets returns the list of the ignored subnets.
+func (hdb *HostDB) IgnoredSubnets() []string {
+	return hdb.ignoredSubnets.list()
+}
+
+// hostStore returns the store the host with the given public key belongs to.
+func (hdb *HostDB) hostStore(pk types.PublicKey) (*hostDBStore, bool) {
+	for _, s := range []*hostDBStore{hdb.s, hdb.sZen} {
+		s.mu.Lock()
+		_, exists := s.hosts[pk]
+		s.mu.Unlock()
+		if exists {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// A ScanCursor marks the position of a scan in the pages returned by
+// ScansInWindow. The zero cursor is before all scans.
+type ScanCursor struct {
+	Timestamp time.Time `json:"timestamp"`
+	Network   string    `json:"network"`
+	ID        int64     `json:"id"`
+}
+
+// Cursor returns the cursor of the scan, which requests the next page
+// if the scan is the last one of a page.
+func (sh ScanHistory) Cursor() ScanCursor {
+	return ScanCursor{Timestamp: sh.Timestamp, Network: sh.Network, ID: sh.ID}
+}
+
+// ScansInWindow returns a page of the scans of all hosts of both networks
+// that were run within the given time range after the cursor, oldest
+// first. The scans run at the same time are ordered by the network and
+// then by the order in which they were recorded, so the pages don't
+// overlap.
+func (hdb *HostDB) ScansInWindow(from, to time.Time, after ScanCursor, limit int) []ScanHistory {
+	if limit <= 0 {
+		return nil
+	}
+	var scans []ScanHistory
+	for _, s := range hdb.stores("") {
+		// Within the second of the cursor, the scans of the networks
+		// ordered before the cursor's one are skipped entirely, and the
+		// scans of the networks ordered after it are all included.
+		id := after.ID
+		if s.network < after.Network {
+			id = math.MaxInt64
+		} else if s.network > after.Network {
+			id = 0
+		}
+		// The page may consist of the scans of one network only.
+		sh, err := s.getScansInWindow(from, to, after.Timestamp.Unix(), id, limit)
+		if err != nil {
+			hdb.log.Error("couldn't get scans", zap.String("network", s.network), zap.Error(err))
+			return nil
+		}
+		scans = append(scans, sh...)
+	}
+	sort.SliceStable(scans, func(i, j int) bool {
+		if !scans[i].Timestamp.Equal(scans[j].Timestamp) {
+			return scans[i].Timestamp.Before(scans[j].Timestamp)
+		}
+		if scans[i].Network != scans[j].Network {
+			return scans[i].Network < scans[j].Network
+		}
+		return scans[i].ID < scans[j].ID
+	})
+	if len(scans) > limit {
+		scans = scans[:limit]
+	}
+	return scans
+}
+
 // FinalizeUpdates updates the timestamps after the client confirms the data receipt.
 func (hdb *HostDB) FinalizeUpdates(id UpdateID) error {
 	return utils.ComposeErrors(hdb.s.finalizeUpdates(id), hdb.sZen.finalizeUpdates(id))
 }
 
+// Flush commits all pending writes of both networks to the database.
+// It is meant to be called from the signal handler of the host
+// application before shutting down.
+func (hdb *HostDB) Flush() error {
+	return utils.ComposeErrors(hdb.s.flush(), hdb.sZen.flush())
+}
+
 // Close shuts down HostDB.
 func (hdb *HostDB) Close() {
 	if err := hdb.tg.Stop(); err != nil {
@@ -166,6 +440,9 @@ func (hdb *HostDB) Close() {
 	}
 	hdb.unsubscribe()
 	hdb.unsubscribeZen()
+	if err := hdb.saveScanState(); err != nil {
+		hdb.log.Error("couldn't save scan state", zap.Error(err))
+	}
 	hdb.s.close()
 	hdb.sZen.close()
 	hdb.closeFn()
@@ -192,21 +469,23 @@ func loadBlockedDomains(db *sql.DB) (*blockedDomains, error) {
 
 func syncStore(store *hostDBStore, cm *chain.Manager, index types.ChainIndex) error {
 	for index != cm.Tip() {
-		_, caus, err := cm.UpdatesSince(index, 1000)
+		crus, caus, err := cm.UpdatesSince(index, 1000)
 		if err != nil {
 			return fmt.Errorf("failed to subscribe to chain manager: height %d, error %w", cm.Tip().Height, err)
-		} else if err := store.updateChainState(caus, len(caus) > 0 && caus[len(caus)-1].State.Index == cm.Tip()); err != nil {
+		} else if err := store.updateChainState(crus, caus, len(caus) > 0 && caus[len(caus)-1].State.Index == cm.Tip()); err != nil {
 			return fmt.Errorf("failed to update chain state: %w", err)
 		}
 		if len(caus) > 0 {
 			index = caus[len(caus)-1].State.Index
+		} else if len(crus) > 0 {
+			index = crus[len(crus)-1].State.Index
 		}
 	}
 	return nil
 }
 
 // NewHostDB returns a new HostDB.
-func NewHostDB(db *sql.DB, dir string, cm *chain.Manager, cmZen *chain.Manager, syncer *syncer.Syncer, syncerZen *syncer.Syncer, w *walletutil.Wallet) (*HostDB, <-chan error) {
+func NewHostDB(db *sql.DB, dir string, cfg persist.HostDBConfig, cm *chain.Manager, cmZen *chain.Manager, syncer *syncer.Syncer, syncerZen *syncer.Syncer, w *walletutil.Wallet) (*HostDB, <-chan error) {
 	errChan := make(chan error, 1)
 	l, closeFn, err := persist.NewFileLogger(filepath.Join(dir, "hostdb.log"))
 	if err != nil {
@@ -230,17 +509,82 @@ func NewHostDB(db *sql.DB, dir string, cm *chain.Manager, cmZen *chain.Manager,
 		return nil, errChan
 	}
 
+	region := cfg.Region
+	if region == "" {
+		region = defaultRegion
+	}
+
+	percentile := cfg.ScanTimeoutPercentile
+	if percentile <= 0 || percentile > 1 {
+		percentile = defaultScanTimeoutPercentile
+	}
+	multiplier := cfg.ScanTimeoutMultiplier
+	if multiplier <= 0 {
+		multiplier = defaultScanTimeoutMultiplier
+	}
+
+	ipChangeMultiplier := cfg.IPChangeScanMultiplier
+	if ipChangeMultiplier <= 0 || ipChangeMultiplier > 1 {
+		ipChangeMultiplier = defaultIPChangeScanMultiplier
+	}
+
+	benchmarkTimeout := time.Duration(cfg.BenchmarkTimeout) * time.Second
+	if benchmarkTimeout <= 0 {
+		benchmarkTimeout = defaultBenchmarkTimeout
+	}
+
+	syncWaitInterval := time.Duration(cfg.SyncWaitInterval) * time.Second
+	if syncWaitInterval < minSyncWaitInterval {
+		syncWaitInterval = defaultSyncWaitInterval
+	}
+
+	successfulScanRetention := cfg.SuccessfulScanRetention
+	if successfulScanRetention <= 0 {
+		successfulScanRetention = defaultScanRetention
+	}
+	failedScanRetention := cfg.FailedScanRetention
+	if failedScanRetention <= 0 {
+		failedScanRetention = defaultScanRetention
+	}
+
+	announcementWorkers := cfg.AnnouncementWorkers
+	if announcementWorkers <= 0 {
+		announcementWorkers = defaultAnnouncementWorkers
+	}
+
+	concurrentBenchmarks := cfg.ConcurrentBenchmarks
+	if concurrentBenchmarks <= 0 {
+		concurrentBenchmarks = 1
+	}
+
+	var publisher ResultPublisher = noopPublisher{}
+	queueSize := publishQueueSize
+	if cfg.Webhook.URL != "" {
+		timeout := time.Duration(cfg.Webhook.Timeout) * time.Second
+		publisher = NewWebhookPublisher(cfg.Webhook.URL, timeout, cfg.Webhook.MaxRetries)
+		if cfg.Webhook.QueueSize > 0 {
+			queueSize = cfg.Webhook.QueueSize
+		}
+	}
+
 	hdb := &HostDB{
-		syncer:    syncer,
-		syncerZen: syncerZen,
-		cm:        cm,
-		cmZen:     cmZen,
-		w:         w,
-		s:         store,
-		sZen:      storeZen,
-		log:       l,
-		closeFn:   closeFn,
-		scanMap:   make(map[types.PublicKey]bool),
+		region:         region,
+		dir:            dir,
+		syncer:         syncer,
+		syncerZen:      syncerZen,
+		cm:             cm,
+		cmZen:          cmZen,
+		w:              w,
+		s:              store,
+		sZen:           storeZen,
+		log:            l,
+		closeFn:        closeFn,
+		scanMap:        make(map[types.PublicKey]bool),
+		inFlight:       make(map[types.PublicKey]struct{}),
+		benchmarkPeaks: make(map[types.PublicKey]int),
+		scanQueue:      make(chan *HostDBEntry, scanBatchSize),
+		publisher:      publisher,
+		publishQueue:   make(chan ScanEvent, queueSize),
 		priceLimits: hostDBPriceLimits{
 			maxContractPrice:     maxContractPrice,
 			maxUploadPrice:       maxUploadPriceSC,
@@ -250,10 +594,37 @@ func NewHostDB(db *sql.DB, dir string, cm *chain.Manager, cmZen *chain.Manager,
 			maxSectorAccessPrice: maxSectorAccessPriceSC,
 		},
 		blockedDomains: domains,
+
+		scanTimeout:            defaultScanTimeout,
+		scanTimeoutPercentile:  percentile,
+		scanTimeoutMultiplier:  multiplier,
+		scanPrivateAddresses:   cfg.ScanPrivateAddresses,
+		ipChangeScanMultiplier: ipChangeMultiplier,
+		benchmarkTimeout:       benchmarkTimeout,
+		syncWaitInterval:       syncWaitInterval,
+		reuseAddress:           !cfg.DisableAddressReuse,
+		scanWhenUnsynced:       cfg.ScanWhenUnsynced,
+		signScans:              cfg.SignScans,
+		groupBySubnet:          cfg.GroupScansBySubnet,
+		announcementWorkers:    announcementWorkers,
+		concurrentBenchmarks:   concurrentBenchmarks,
+
+		successfulScanRetention: successfulScanRetention,
+		failedScanRetention:     failedScanRetention,
 	}
 	hdb.s.hdb = hdb
 	hdb.sZen.hdb = hdb
 
+	for _, s := range hdb.stores("") {
+		if err := s.backfill(); err != nil {
+			l.Error("couldn't backfill hosts", zap.String("network", s.network), zap.Error(err))
+		}
+	}
+
+	if err := hdb.loadScanState(); err != nil {
+		l.Error("couldn't load scan state", zap.Error(err))
+	}
+
 	// Subscribe in a goroutine to prevent blocking.
 	go func() {
 		for hdb.cm.Tip().Height <= tip.Height {
@@ -312,6 +683,9 @@ func NewHostDB(db *sql.DB, dir string, cm *chain.Manager, cmZen *chain.Manager,
 	// Fetch SC rate.
 	go hdb.updateSCRate()
 
+	// Start publishing the scan results.
+	go hdb.runPublisher()
+
 	// Start the scanning thread.
 	go hdb.scanHosts()
 
@@ -332,6 +706,22 @@ func (hdb *HostDB) online(network string) bool {
 	panic("wrong network provided")
 }
 
+// scannable returns true if the hosts of the network can be scanned. This
+// requires HostDB to be synced, unless ScanWhenUnsynced is set, in which
+// case it is enough to have a peer and a non-zero tip.
+func (hdb *HostDB) scannable(network string) bool {
+	if hdb.synced(network) {
+		return true
+	}
+	if !hdb.scanWhenUnsynced || !hdb.online(network) {
+		return false
+	}
+	if network == "zen" {
+		return hdb.cmZen.Tip().Height > 0
+	}
+	return hdb.cm.Tip().Height > 0
+}
+
 // updateSCRate periodically fetches the SC exchange rate.
 func (hdb *HostDB) updateSCRate() {
 	if err := hdb.tg.Add(); err != nil {
@@ -8,6 +8,15 @@ const (
 	interactionDecay       = 0.9995
 	interactionDecayLimit  = 500
 	interactionWeightLimit = 0.01
+
+	// scanWeight is the weight of an interaction during a full scan or
+	// a benchmark.
+	scanWeight = 1.0
+
+	// pingWeight is the weight of an interaction during a ping. A ping
+	// is cheap and can be frequent, so it should not dominate the
+	// interaction history.
+	pingWeight = 0.25
 )
 
 // updateHistoricInteractions updates a HostDBEntries's historic interactions if more
@@ -27,6 +36,18 @@ func (hdb *HostDB) updateHostHistoricInteractions(host *HostDBEntry) {
 	} else {
 		height = hdb.s.tip.Height
 	}
+	// As long as the host has no history, there is nothing to decay and
+	// nothing the recent interactions could dominate. Seed the history
+	// with them right away instead of waiting for the next block, so that
+	// the ratio reflects the first interactions as soon as possible.
+	if host.Interactions.HistoricSuccesses == 0 && host.Interactions.HistoricFailures == 0 {
+		host.Interactions.HistoricSuccesses = host.Interactions.RecentSuccesses
+		host.Interactions.HistoricFailures = host.Interactions.RecentFailures
+		host.Interactions.RecentSuccesses = 0
+		host.Interactions.RecentFailures = 0
+		host.Interactions.LastUpdate = height
+		return
+	}
 	// Check that the last historic update was not in the future.
 	if host.Interactions.LastUpdate >= height {
 		// The hostdb may be performing a rescan, or maybe no time has passed
@@ -85,20 +106,20 @@ func (hdb *HostDB) updateHostHistoricInteractions(host *HostDBEntry) {
 }
 
 // IncrementSuccessfulInteractions increments the number of successful
-// interactions with a given host.
-func (hdb *HostDB) IncrementSuccessfulInteractions(host *HostDBEntry) error {
+// interactions with a given host by the given weight.
+func (hdb *HostDB) IncrementSuccessfulInteractions(host *HostDBEntry, weight float64) error {
 	// Update historic values if necessary.
 	hdb.updateHostHistoricInteractions(host)
 
 	// Increment the successful interactions.
-	host.Interactions.RecentSuccesses++
+	host.Interactions.RecentSuccesses += weight
 
 	return nil
 }
 
 // IncrementFailedInteractions increments the number of failed interactions with
-// a given host.
-func (hdb *HostDB) IncrementFailedInteractions(host *HostDBEntry) error {
+// a given host by the given weight.
+func (hdb *HostDB) IncrementFailedInteractions(host *HostDBEntry, weight float64) error {
 	// If we are offline it probably wasn't the host's fault.
 	if !hdb.online(host.Network) {
 		return nil
@@ -108,7 +129,7 @@ func (hdb *HostDB) IncrementFailedInteractions(host *HostDBEntry) error {
 	hdb.updateHostHistoricInteractions(host)
 
 	// Increment the failed interactions.
-	host.Interactions.RecentFailures++
+	host.Interactions.RecentFailures += weight
 
 	return nil
 }
@@ -0,0 +1,50 @@
+package hostdb
+
+import (
+	"math"
+	"testing"
+)
+
+// reliability returns the share of the successful historic interactions.
+func reliability(host *HostDBEntry) float64 {
+	hs, hf := host.Interactions.HistoricSuccesses, host.Interactions.HistoricFailures
+	if hs+hf == 0 {
+		return math.NaN()
+	}
+	return hs / (hs + hf)
+}
+
+// TestNewHostReliability checks that the interaction history of a new
+// host reflects its first scans and converges to the actual success rate.
+func TestNewHostReliability(t *testing.T) {
+	hdb := newTestHostDB()
+	host := randomHost()
+	hdb.s.tip.Height = 1000
+
+	// The first two scans happen within the same block. The history must
+	// not stay empty until the next block.
+	hdb.IncrementSuccessfulInteractions(host, scanWeight)
+	hdb.IncrementSuccessfulInteractions(host, scanWeight)
+	if r := reliability(host); r != 1 {
+		t.Fatalf("expected reliability 1 after two successful scans, got %v", r)
+	}
+
+	// Three out of four scans succeed from now on.
+	for i := 0; i < 400; i++ {
+		if i%6 == 0 {
+			hdb.s.tip.Height++
+		}
+		if i%4 == 3 {
+			// IncrementFailedInteractions needs a syncer, so the
+			// failure is recorded the same way by hand.
+			hdb.updateHostHistoricInteractions(host)
+			host.Interactions.RecentFailures += scanWeight
+		} else {
+			hdb.IncrementSuccessfulInteractions(host, scanWeight)
+		}
+	}
+	hdb.updateHostHistoricInteractions(host)
+	if r := reliability(host); math.Abs(r-0.75) > 0.02 {
+		t.Fatalf("expected reliability to converge to 0.75, got %v", r)
+	}
+}
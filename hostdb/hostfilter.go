@@ -4,16 +4,74 @@ import (
 	"net"
 	"strings"
 	"sync"
+
+	"go.sia.tech/core/types"
 )
 
+type ignoredSubnets struct {
+	subnets []*net.IPNet
+	mu      sync.Mutex
+}
+
+func (is *ignoredSubnets) add(subnet *net.IPNet) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	for _, s := range is.subnets {
+		if s.String() == subnet.String() {
+			return
+		}
+	}
+	is.subnets = append(is.subnets, subnet)
+}
+
+func (is *ignoredSubnets) list() []string {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	var subnets []string
+	for _, s := range is.subnets {
+		subnets = append(subnets, s.String())
+	}
+	return subnets
+}
+
+// isIgnored returns true if any of the host's subnets overlaps with
+// an ignored subnet.
+func (is *ignoredSubnets) isIgnored(ipNets []string) bool {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	return subnetsOverlap(ipNets, is.subnets)
+}
+
+// subnetsOverlap returns true if any of the host's subnets overlaps with
+// any of the given subnets.
+func subnetsOverlap(ipNets []string, subnets []*net.IPNet) bool {
+	for _, ipNet := range ipNets {
+		_, n, err := net.ParseCIDR(ipNet)
+		if err != nil {
+			continue
+		}
+		for _, s := range subnets {
+			if s.Contains(n.IP) || n.Contains(s.IP) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// blockedDomains holds the blocked domains and subnets. It also holds
+// the public keys of the blocked hosts that were not known when they
+// were blocked, so that they are blocked as soon as they announce.
 type blockedDomains struct {
 	domains map[string]struct{}
+	keys    map[types.PublicKey]struct{}
 	mu      sync.Mutex
 }
 
 func newBlockedDomains(domains []string) *blockedDomains {
 	blocked := &blockedDomains{
 		domains: make(map[string]struct{}),
+		keys:    make(map[types.PublicKey]struct{}),
 	}
 	blocked.addDomains(domains)
 	return blocked
@@ -23,6 +81,11 @@ func (bd *blockedDomains) addDomains(domains []string) {
 	bd.mu.Lock()
 	defer bd.mu.Unlock()
 	for _, domain := range domains {
+		var pk types.PublicKey
+		if err := pk.UnmarshalText([]byte(domain)); err == nil {
+			bd.keys[pk] = struct{}{}
+			continue
+		}
 		bd.domains[domain] = struct{}{}
 
 		addrs, err := net.LookupHost(domain)
@@ -35,6 +98,21 @@ func (bd *blockedDomains) addDomains(domains []string) {
 	}
 }
 
+func (bd *blockedDomains) has(domain string) bool {
+	bd.mu.Lock()
+	defer bd.mu.Unlock()
+	_, exists := bd.domains[domain]
+	return exists
+}
+
+// isKeyBlocked returns true if the public key was blocked.
+func (bd *blockedDomains) isKeyBlocked(pk types.PublicKey) bool {
+	bd.mu.Lock()
+	defer bd.mu.Unlock()
+	_, blocked := bd.keys[pk]
+	return blocked
+}
+
 func (bd *blockedDomains) isBlocked(addr string) bool {
 	bd.mu.Lock()
 	defer bd.mu.Unlock()
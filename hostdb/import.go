@@ -0,0 +1,111 @@
+package hostdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+)
+
+// maxHostListSize is the maximum size of a host list that can be
+// imported.
+const maxHostListSize = 64 << 20 // 64 MiB
+
+// An ImportedHost is an entry of a host list. The list is a JSON array
+// of these entries, which is the format used by the Sia explorers:
+//
+//	[{"publicKey": "ed25519:...", "netAddress": "host.example.com:9982"}]
+//
+// The network defaults to Mainnet.
+type ImportedHost struct {
+	PublicKey  types.PublicKey `json:"publicKey"`
+	NetAddress string          `json:"netAddress"`
+	Network    string          `json:"network,omitempty"`
+}
+
+// ImportHostsFromURL fetches a host list from the URL and adds the hosts
+// not known yet to HostDB, so that they can be scanned before their
+// announcements are found in the blockchain. The number of the added
+// hosts is returned.
+func (hdb *HostDB) ImportHostsFromURL(ctx context.Context, url string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, utils.AddContext(err, "couldn't create request")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, utils.AddContext(err, "couldn't fetch host list")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("couldn't fetch host list: %s", resp.Status)
+	}
+
+	var hosts []ImportedHost
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxHostListSize)).Decode(&hosts); err != nil {
+		return 0, utils.AddContext(err, "couldn't decode host list")
+	}
+
+	var count int
+	for _, h := range hosts {
+		s := hdb.s
+		switch h.Network {
+		case "", "mainnet":
+		case "zen":
+			s = hdb.sZen
+		default:
+			continue
+		}
+		added, err := s.addHost(h.PublicKey, h.NetAddress)
+		if err != nil {
+			return count, utils.AddContext(err, "couldn't add host")
+		}
+		if added {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// addHost adds a host that is not known yet and queues it for a scan.
+// Invalid and local addresses, as well as the ignored subnets, are
+// skipped.
+func (s *hostDBStore) addHost(pk types.PublicKey, addr string) (bool, error) {
+	if err := utils.IsValid(addr); err != nil || utils.IsLocal(addr) {
+		return false, nil
+	}
+	ipNets, lookupErr := utils.LookupIPNets(addr)
+	if lookupErr == nil && s.hdb.ignoredSubnets.isIgnored(ipNets) {
+		return false, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.hosts[pk]; exists {
+		return false, nil
+	}
+	host, err := s.newHost(pk)
+	if err != nil {
+		return false, err
+	}
+	host.FirstSeen = time.Now()
+	host.NetAddress = addr
+	if lookupErr == nil {
+		host.IPNets = ipNets
+		host.LastIPChange = time.Now()
+	}
+	host.PreviousKeys = s.previousKeys(addr, pk)
+	if err := s.update(host); err != nil {
+		return false, err
+	}
+	if !host.Blocked {
+		s.hdb.queueScan(host)
+	}
+	return true, nil
+}
@@ -0,0 +1,106 @@
+package hostdb
+
+import (
+	"time"
+)
+
+// metricsWindow is the period the scanner metrics are calculated over.
+const metricsWindow = time.Hour
+
+// cachedLookupTime is the duration below which a DNS lookup is assumed
+// to have been answered from a cache.
+const cachedLookupTime = 5 * time.Millisecond
+
+// completion records when a scan or a benchmark finished and how long
+// it took.
+type completion struct {
+	at       time.Time
+	duration time.Duration
+}
+
+// ScannerMetrics describes the throughput of the scanner over the last
+// hour.
+type ScannerMetrics struct {
+	ScansPerMinute      float64       `json:"scansPerMinute"`
+	BenchmarksPerHour   float64       `json:"benchmarksPerHour"`
+	AverageScanDuration time.Duration `json:"averageScanDuration"`
+	ScanThreads         int           `json:"scanThreads"`
+	BenchmarkThreads    int           `json:"benchmarkThreads"`
+	ScanQueue           int           `json:"scanQueue"`
+	BenchmarkQueue      int           `json:"benchmarkQueue"`
+
+	// SubnetSwitches is the number of times since the start that a scan
+	// was dispatched to a different subnet than the previous one.
+	SubnetSwitches uint64 `json:"subnetSwitches"`
+
+	// DNSLookups is the number of DNS lookups made by the scans since
+	// the start, and SlowDNSLookups is the number of them that took
+	// longer than a cached answer would. Grouping the scans by subnet
+	// should reduce the latter.
+	DNSLookups     uint64 `json:"dnsLookups"`
+	SlowDNSLookups uint64 `json:"slowDNSLookups"`
+}
+
+// pruneCompletions removes the completions older than metricsWindow.
+func pruneCompletions(completions []completion) []completion {
+	cutoff := time.Now().Add(-metricsWindow)
+	i := 0
+	for i < len(completions) && completions[i].at.Before(cutoff) {
+		i++
+	}
+	return completions[i:]
+}
+
+// recordScan records a finished scan.
+func (hdb *HostDB) recordScan(duration time.Duration) {
+	hdb.mu.Lock()
+	defer hdb.mu.Unlock()
+	hdb.scanCompletions = append(pruneCompletions(hdb.scanCompletions), completion{time.Now(), duration})
+}
+
+// recordBenchmark records a finished benchmark.
+func (hdb *HostDB) recordBenchmark(duration time.Duration) {
+	hdb.mu.Lock()
+	defer hdb.mu.Unlock()
+	hdb.benchmarkCompletions = append(pruneCompletions(hdb.benchmarkCompletions), completion{time.Now(), duration})
+}
+
+// recordDNSLookup records a DNS lookup made by a scan.
+func (hdb *HostDB) recordDNSLookup(duration time.Duration) {
+	hdb.mu.Lock()
+	defer hdb.mu.Unlock()
+	hdb.dnsLookups++
+	if duration >= cachedLookupTime {
+		hdb.slowDNSLookups++
+	}
+}
+
+// ScannerMetrics returns the throughput of the scanner. Together with
+// the queue lengths, this tells a slow network apart from too few scan
+// threads.
+func (hdb *HostDB) ScannerMetrics() ScannerMetrics {
+	hdb.mu.Lock()
+	defer hdb.mu.Unlock()
+	hdb.scanCompletions = pruneCompletions(hdb.scanCompletions)
+	hdb.benchmarkCompletions = pruneCompletions(hdb.benchmarkCompletions)
+
+	var total time.Duration
+	for _, c := range hdb.scanCompletions {
+		total += c.duration
+	}
+	metrics := ScannerMetrics{
+		ScansPerMinute:    float64(len(hdb.scanCompletions)) / metricsWindow.Minutes(),
+		BenchmarksPerHour: float64(len(hdb.benchmarkCompletions)) / metricsWindow.Hours(),
+		ScanThreads:       hdb.scanThreads,
+		BenchmarkThreads:  hdb.benchmarkThreads,
+		ScanQueue:         len(hdb.scanList) + len(hdb.scanQueue),
+		BenchmarkQueue:    len(hdb.benchmarkList),
+		SubnetSwitches:    hdb.subnetSwitches,
+		DNSLookups:        hdb.dnsLookups,
+		SlowDNSLookups:    hdb.slowDNSLookups,
+	}
+	if len(hdb.scanCompletions) > 0 {
+		metrics.AverageScanDuration = total / time.Duration(len(hdb.scanCompletions))
+	}
+	return metrics
+}
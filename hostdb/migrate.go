@@ -0,0 +1,178 @@
+package hostdb
+
+import (
+	"strings"
+
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.uber.org/zap"
+)
+
+// tableMigrations create the tables that are missing from a database
+// created with an older version of init.sql. NET stands for the network.
+var tableMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS hdb_ip_changes_NET (
+		id          BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+		public_key  BINARY(32) NOT NULL,
+		changed_at  BIGINT NOT NULL,
+		net_address VARCHAR(255) NOT NULL,
+		ip_nets     TEXT NOT NULL,
+		PRIMARY KEY (id),
+		FOREIGN KEY (public_key) REFERENCES hdb_hosts_NET(public_key)
+	)`,
+	`CREATE TABLE IF NOT EXISTS hdb_removed_NET (
+		public_key  BINARY(32) NOT NULL,
+		removed_at  BIGINT NOT NULL,
+		PRIMARY KEY (public_key)
+	)`,
+	`CREATE TABLE IF NOT EXISTS hdb_notes_NET (
+		id          BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+		public_key  BINARY(32) NOT NULL,
+		created_at  BIGINT NOT NULL,
+		note        TEXT NOT NULL,
+		PRIMARY KEY (id),
+		FOREIGN KEY (public_key) REFERENCES hdb_hosts_NET(public_key)
+	)`,
+	`CREATE TABLE IF NOT EXISTS hdb_rollups_NET (
+		id          BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+		public_key  BINARY(32) NOT NULL,
+		day         BIGINT NOT NULL,
+		successes   INT NOT NULL,
+		failures    INT NOT NULL,
+		min_latency DOUBLE NOT NULL,
+		avg_latency DOUBLE NOT NULL,
+		max_latency DOUBLE NOT NULL,
+		settings    BLOB,
+		PRIMARY KEY (id),
+		INDEX idx_public_key_day (public_key, day),
+		FOREIGN KEY (public_key) REFERENCES hdb_hosts_NET(public_key)
+	)`,
+}
+
+// A columnMigration adds a column that is missing from a database created
+// with an older version of init.sql.
+type columnMigration struct {
+	table      string
+	column     string
+	definition string
+
+	// denormalized columns duplicate a value kept in the host entry.
+	// They are filled after the hosts are loaded.
+	denormalized bool
+}
+
+// columnMigrations are applied to the tables of both networks, in order.
+var columnMigrations = []columnMigration{
+	{"hdb_scans", "region", "VARCHAR(64) NOT NULL DEFAULT ''", false},
+	{"hdb_scans", "rhp3_ttfb", "DOUBLE NOT NULL DEFAULT 0", false},
+	{"hdb_scans", "price_table_fetch", "DOUBLE NOT NULL DEFAULT 0", false},
+	{"hdb_hosts", "scan_interval", "BIGINT NOT NULL DEFAULT 0", false},
+	{"hdb_scans", "warnings", "VARCHAR(1024) NOT NULL DEFAULT ''", false},
+	{"hdb_hosts", "accepting_contracts", "BOOL NOT NULL DEFAULT FALSE", true},
+	{"hdb_hosts", "remaining_storage", "BIGINT UNSIGNED NOT NULL DEFAULT 0", true},
+	{"hdb_hosts", "storage_price", "DOUBLE NOT NULL DEFAULT 0", true},
+	{"hdb_benchmarks", "error_category", "VARCHAR(16) NOT NULL DEFAULT ''", false},
+	{"hdb_hosts", "priority", "INT NOT NULL DEFAULT 0", false},
+	{"hdb_benchmarks", "partial", "BOOL NOT NULL DEFAULT FALSE", false},
+	{"hdb_scans", "siamux_port", "VARCHAR(8) NOT NULL DEFAULT ''", false},
+	{"hdb_hosts", "max_duration", "BIGINT UNSIGNED NOT NULL DEFAULT 0", true},
+	{"hdb_hosts", "favorite", "BOOL NOT NULL DEFAULT FALSE", false},
+	{"hdb_hosts", "previous_keys", "VARCHAR(4096) NOT NULL DEFAULT ''", false},
+	{"hdb_hosts", "benchmarked_at", "BIGINT NOT NULL DEFAULT 0", true},
+	{"hdb_hosts", "upload_speed", "DOUBLE NOT NULL DEFAULT 0", true},
+	{"hdb_hosts", "download_speed", "DOUBLE NOT NULL DEFAULT 0", true},
+	{"hdb_benchmarks", "concurrency", "INT NOT NULL DEFAULT 1", false},
+	{"hdb_scans", "signature", "BLOB", false},
+	{"hdb_scans", "ever_online", "BOOL NOT NULL DEFAULT FALSE", false},
+}
+
+// An indexMigration adds an index that is missing from a database created
+// with an older version of init.sql.
+type indexMigration struct {
+	table   string
+	index   string
+	columns string
+}
+
+// indexMigrations are applied to the tables of both networks after the
+// column migrations.
+var indexMigrations = []indexMigration{
+	{"hdb_hosts", "idx_favorite", "favorite"},
+}
+
+// migrate brings the tables of the network up to date with init.sql.
+func (s *hostDBStore) migrate() error {
+	for _, m := range tableMigrations {
+		if _, err := s.db.Exec(strings.ReplaceAll(m, "NET", s.network)); err != nil {
+			return utils.AddContext(err, "couldn't create table")
+		}
+	}
+
+	for _, m := range columnMigrations {
+		table := m.table + "_" + s.network
+		var count int
+		err := s.db.QueryRow(`
+			SELECT COUNT(*)
+			FROM information_schema.columns
+			WHERE table_schema = DATABASE()
+			AND table_name = ?
+			AND column_name = ?
+		`, table, m.column).Scan(&count)
+		if err != nil {
+			return utils.AddContext(err, "couldn't query columns of "+table)
+		}
+		if count > 0 {
+			continue
+		}
+		_, err = s.db.Exec("ALTER TABLE " + table + " ADD COLUMN " + m.column + " " + m.definition)
+		if err != nil {
+			return utils.AddContext(err, "couldn't add column "+m.column+" to "+table)
+		}
+		s.log.Info("added column", zap.String("table", table), zap.String("column", m.column))
+		if m.denormalized {
+			s.needsBackfill = true
+		}
+	}
+
+	for _, m := range indexMigrations {
+		table := m.table + "_" + s.network
+		var count int
+		err := s.db.QueryRow(`
+			SELECT COUNT(*)
+			FROM information_schema.statistics
+			WHERE table_schema = DATABASE()
+			AND table_name = ?
+			AND index_name = ?
+		`, table, m.index).Scan(&count)
+		if err != nil {
+			return utils.AddContext(err, "couldn't query indexes of "+table)
+		}
+		if count > 0 {
+			continue
+		}
+		_, err = s.db.Exec("CREATE INDEX " + m.index + " ON " + table + " (" + m.columns + ")")
+		if err != nil {
+			return utils.AddContext(err, "couldn't add index "+m.index+" to "+table)
+		}
+		s.log.Info("added index", zap.String("table", table), zap.String("index", m.index))
+	}
+	return nil
+}
+
+// backfill writes all hosts again if migrate added a denormalized column.
+// Otherwise, the existing hosts would appear to have no settings, e.g.
+// a zero storage price, until they are scanned again.
+func (s *hostDBStore) backfill() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.needsBackfill {
+		return nil
+	}
+	for _, host := range s.hosts {
+		if err := s.update(host); err != nil {
+			return utils.AddContext(err, "couldn't update host")
+		}
+	}
+	s.needsBackfill = false
+	s.log.Info("backfilled hosts", zap.String("network", s.network), zap.Int("hosts", len(s.hosts)))
+	return nil
+}
@@ -0,0 +1,76 @@
+package hostdb
+
+import (
+	"errors"
+	"time"
+
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+)
+
+// A HostNote is a free-form note an operator has left on a host.
+type HostNote struct {
+	ID        int64     `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Text      string    `json:"text"`
+}
+
+// AddNote adds a note to the host.
+func (hdb *HostDB) AddNote(pk types.PublicKey, text string) error {
+	if text == "" {
+		return errors.New("empty note")
+	}
+	s, exists := hdb.hostStore(pk)
+	if !exists {
+		return ErrHostNotFound
+	}
+	return s.addNote(pk, text)
+}
+
+// Notes returns the notes left on the host, oldest first.
+func (hdb *HostDB) Notes(pk types.PublicKey) ([]HostNote, error) {
+	s, exists := hdb.hostStore(pk)
+	if !exists {
+		return nil, ErrHostNotFound
+	}
+	return s.getNotes(pk)
+}
+
+// addNote saves a note on the host.
+func (s *hostDBStore) addNote(pk types.PublicKey, text string) error {
+	_, err := s.db.Exec(`
+		INSERT INTO hdb_notes_`+s.network+` (public_key, created_at, note)
+		VALUES (?, ?, ?)
+	`, pk[:], time.Now().Unix(), text)
+	return utils.AddContext(err, "couldn't save note")
+}
+
+// getNotes returns the notes on the host.
+func (s *hostDBStore) getNotes(pk types.PublicKey) ([]HostNote, error) {
+	rows, err := s.db.Query(`
+		SELECT id, created_at, note
+		FROM hdb_notes_`+s.network+`
+		WHERE public_key = ?
+		ORDER BY id ASC
+	`, pk[:])
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't query notes")
+	}
+	defer rows.Close()
+
+	var notes []HostNote
+	for rows.Next() {
+		var id, ca int64
+		var text string
+		if err := rows.Scan(&id, &ca, &text); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode note")
+		}
+		notes = append(notes, HostNote{
+			ID:        id,
+			Timestamp: time.Unix(ca, 0),
+			Text:      text,
+		})
+	}
+
+	return notes, nil
+}
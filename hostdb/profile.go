@@ -0,0 +1,116 @@
+package hostdb
+
+import (
+	"math"
+
+	"go.sia.tech/core/types"
+)
+
+// A RenterProfile describes the requirements and the priorities of
+// a renter. The zero values of the requirements mean no limit. If all
+// weights are zero, the criteria are weighted equally.
+type RenterProfile struct {
+	MinStorage       uint64         `json:"minStorage"`
+	MaxStoragePrice  types.Currency `json:"maxStoragePrice"`
+	MaxUploadPrice   types.Currency `json:"maxUploadPrice"`
+	MaxDownloadPrice types.Currency `json:"maxDownloadPrice"`
+	MinUptime        float64        `json:"minUptime"`
+
+	PriceWeight   float64 `json:"priceWeight"`
+	SpeedWeight   float64 `json:"speedWeight"`
+	LatencyWeight float64 `json:"latencyWeight"`
+	UptimeWeight  float64 `json:"uptimeWeight"`
+}
+
+// meets returns true if the host meets the hard requirements of the
+// profile.
+func (p RenterProfile) meets(host HostDBEntry) bool {
+	if host.Blocked || !isOnline(&host) {
+		return false
+	}
+	if host.Settings.RemainingStorage < p.MinStorage {
+		return false
+	}
+	if !p.MaxStoragePrice.IsZero() && host.Settings.StoragePrice.Cmp(p.MaxStoragePrice) > 0 {
+		return false
+	}
+	if !p.MaxUploadPrice.IsZero() && host.Settings.UploadBandwidthPrice.Cmp(p.MaxUploadPrice) > 0 {
+		return false
+	}
+	if !p.MaxDownloadPrice.IsZero() && host.Settings.DownloadBandwidthPrice.Cmp(p.MaxDownloadPrice) > 0 {
+		return false
+	}
+	return uptimeRatio(host) >= p.MinUptime
+}
+
+// uptimeRatio returns the share of the time the host was online.
+func uptimeRatio(host HostDBEntry) float64 {
+	total := host.Uptime + host.Downtime
+	if total == 0 {
+		return 0
+	}
+	return float64(host.Uptime) / float64(total)
+}
+
+// ScoreForProfile returns how well the host fits the renter profile, from
+// 0 to 1. A host that violates any of the hard requirements scores 0.
+// Otherwise, the prices, the speed, and the latency are ranked among the
+// online hosts of the same network and combined with the uptime using the
+// weights of the profile.
+func (hdb *HostDB) ScoreForProfile(pk types.PublicKey, profile RenterProfile) (float64, error) {
+	s, exists := hdb.hostStore(pk)
+	if !exists {
+		return 0, ErrHostNotFound
+	}
+	host, exists := s.hostEntry(pk)
+	if !exists {
+		return 0, ErrHostNotFound
+	}
+	if !profile.meets(host) {
+		return 0, nil
+	}
+
+	var features []hostFeatures
+	for _, h := range s.onlineHosts() {
+		if h.PublicKey != pk {
+			features = append(features, featuresOf(h))
+		}
+	}
+	features = append(features, featuresOf(host))
+	normalizeFeatures(features)
+	f := features[len(features)-1]
+
+	// The features are storage, upload, and download prices, latency,
+	// upload speed, and download speed. The unknown ones score 0.
+	known := func(v float64) float64 {
+		if math.IsNaN(v) {
+			return 0
+		}
+		return v
+	}
+	price := 1 - (f[0]+f[1]+f[2])/3
+	latency := 0.0
+	if !math.IsNaN(f[3]) {
+		latency = 1 - f[3]
+	}
+	speed := (known(f[4]) + known(f[5])) / 2
+	uptime := uptimeRatio(host)
+
+	weights := []float64{profile.PriceWeight, profile.SpeedWeight, profile.LatencyWeight, profile.UptimeWeight}
+	scores := []float64{price, speed, latency, uptime}
+	var total, sum float64
+	for i, w := range weights {
+		if w < 0 {
+			w = 0
+		}
+		total += w
+		sum += w * scores[i]
+	}
+	if total == 0 {
+		for _, score := range scores {
+			sum += score
+		}
+		total = float64(len(scores))
+	}
+	return sum / total, nil
+}
@@ -0,0 +1,100 @@
+package hostdb
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+const (
+	// publishQueueSize is the number of scan events that can wait
+	// for publishing before new events are dropped.
+	publishQueueSize = 1000
+
+	// publishTimeout is the time a publisher has to process one event.
+	publishTimeout = 30 * time.Second
+)
+
+// A ScanEvent is published after a scan has been recorded.
+type ScanEvent struct {
+	PublicKey  types.PublicKey `json:"publicKey"`
+	Network    string          `json:"network"`
+	NetAddress string          `json:"netaddress"`
+	Scan       HostScan        `json:"scan"`
+}
+
+// A ResultPublisher forwards the scan results to an external system,
+// e.g. a message broker.
+type ResultPublisher interface {
+	Publish(ctx context.Context, event ScanEvent) error
+}
+
+// noopPublisher is the default ResultPublisher, which discards all events.
+type noopPublisher struct{}
+
+// Publish implements ResultPublisher.
+func (noopPublisher) Publish(context.Context, ScanEvent) error {
+	return nil
+}
+
+// SetPublisher sets the ResultPublisher the scan results are sent to.
+// Passing nil disables publishing.
+func (hdb *HostDB) SetPublisher(p ResultPublisher) {
+	if p == nil {
+		p = noopPublisher{}
+	}
+	hdb.mu.Lock()
+	hdb.publisher = p
+	hdb.mu.Unlock()
+}
+
+// publishScan queues the scan for publishing. If the queue is full,
+// the event is dropped, so that the scanning is never blocked.
+func (hdb *HostDB) publishScan(host *HostDBEntry, scan HostScan) {
+	event := ScanEvent{
+		PublicKey:  host.PublicKey,
+		Network:    host.Network,
+		NetAddress: host.NetAddress,
+		Scan:       scan,
+	}
+	select {
+	case hdb.publishQueue <- event:
+	default:
+		dropped := atomic.AddUint64(&hdb.droppedEvents, 1)
+		hdb.log.Warn("publish queue full, dropping scan event", zap.Stringer("host", host.PublicKey), zap.Uint64("dropped", dropped))
+	}
+}
+
+// DroppedScanEvents returns the number of scan events that were dropped
+// because the publish queue was full.
+func (hdb *HostDB) DroppedScanEvents() uint64 {
+	return atomic.LoadUint64(&hdb.droppedEvents)
+}
+
+// runPublisher sends the queued scan events to the publisher.
+func (hdb *HostDB) runPublisher() {
+	if err := hdb.tg.Add(); err != nil {
+		hdb.log.Error("couldn't add a thread", zap.Error(err))
+		return
+	}
+	defer hdb.tg.Done()
+
+	for {
+		select {
+		case <-hdb.tg.StopChan():
+			return
+		case event := <-hdb.publishQueue:
+			hdb.mu.Lock()
+			p := hdb.publisher
+			hdb.mu.Unlock()
+			ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+			if err := p.Publish(ctx, event); err != nil {
+				hdb.log.Error("couldn't publish scan event", zap.Stringer("host", event.PublicKey), zap.Error(err))
+			}
+			cancel()
+		}
+	}
+}
@@ -0,0 +1,390 @@
+package hostdb
+
+import (
+	"math"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+// A HostQuery describes the constraints the hosts found by FindHosts
+// must satisfy.
+type HostQuery struct {
+	// Network is the network the hosts belong to. Defaults to Mainnet.
+	Network string `json:"network"`
+
+	// MinRemainingStorage is the minimum free storage in bytes.
+	MinRemainingStorage uint64 `json:"minRemainingStorage"`
+
+	// MinUptime is the minimum uptime in percent.
+	MinUptime float64 `json:"minUptime"`
+
+	// AcceptingContracts requires the hosts to accept new contracts.
+	AcceptingContracts bool `json:"acceptingContracts"`
+
+	// ExcludeSubnets lists the subnets the hosts must not belong to.
+	// Of the remaining hosts, at most one per subnet is returned.
+	ExcludeSubnets []string `json:"excludeSubnets"`
+
+	// MaxScanAge is how recent the successful last scan of a host must
+	// be. Zero means no limit.
+	MaxScanAge time.Duration `json:"maxScanAge"`
+
+	// Limit is the maximum number of the hosts returned.
+	Limit int `json:"limit"`
+}
+
+// A HostFilter describes the constraints the hosts listed by QueryHosts
+// must satisfy. The zero values of the fields mean no constraint.
+type HostFilter struct {
+	// Network is the network the hosts belong to. Defaults to Mainnet.
+	Network string `json:"network"`
+
+	// Online requires the last scan of the hosts to be successful.
+	Online bool `json:"online"`
+
+	// MaxScanAge is how recent the successful last scan of an online
+	// host must be. Zero means no limit.
+	MaxScanAge time.Duration `json:"maxScanAge"`
+
+	// MinRemainingStorage is the minimum free storage in bytes.
+	MinRemainingStorage uint64 `json:"minRemainingStorage"`
+
+	// MinUptime is the minimum uptime in percent.
+	MinUptime float64 `json:"minUptime"`
+
+	// AcceptingContracts requires the hosts to accept new contracts.
+	AcceptingContracts bool `json:"acceptingContracts"`
+
+	// Favorite requires the hosts to be favorites.
+	Favorite bool `json:"favorite"`
+
+	// MaxStoragePrice is the maximum storage price in SC/TB/month.
+	// Zero means no limit.
+	MaxStoragePrice float64 `json:"maxStoragePrice"`
+
+	// MinUploadSpeed and MinDownloadSpeed are the minimum speeds in MB/s
+	// measured by a recent benchmark.
+	MinUploadSpeed   float64 `json:"minUploadSpeed"`
+	MinDownloadSpeed float64 `json:"minDownloadSpeed"`
+
+	// SortBy is the field the hosts are sorted by: "id" (the default),
+	// "price", "uptime", "remainingStorage", "uploadSpeed", or
+	// "downloadSpeed".
+	SortBy string `json:"sortBy"`
+
+	// Descending reverses the sort order.
+	Descending bool `json:"descending"`
+
+	// Offset is the number of the matching hosts skipped.
+	Offset int `json:"offset"`
+
+	// Limit is the maximum number of the hosts returned.
+	Limit int `json:"limit"`
+}
+
+// hostSortFields maps the HostFilter sort fields to the columns.
+var hostSortFields = map[string]string{
+	"":                 "id",
+	"id":               "id",
+	"price":            "storage_price",
+	"uptime":           "uptime / (uptime + downtime + 1)",
+	"remainingStorage": "remaining_storage",
+	"uploadSpeed":      "upload_speed",
+	"downloadSpeed":    "download_speed",
+}
+
+// stores returns the stores of the given network. An empty network
+// means both networks.
+func (hdb *HostDB) stores(network string) []*hostDBStore {
+	switch network {
+	case "mainnet":
+		return []*hostDBStore{hdb.s}
+	case "zen":
+		return []*hostDBStore{hdb.sZen}
+	default:
+		return []*hostDBStore{hdb.s, hdb.sZen}
+	}
+}
+
+// queryHosts selects the hosts from the given stores that satisfy the
+// condition, sorted in the specified order. The condition and the order
+// may only refer to the columns of the hosts tables, the arguments are
+// applied to each network separately.
+func (hdb *HostDB) queryHosts(stores []*hostDBStore, cond string, args []interface{}, order string, offset, limit int) ([]HostDBEntry, error) {
+	var queries []string
+	var queryArgs []interface{}
+	for _, s := range stores {
+		queries = append(queries, `
+			SELECT '`+s.network+`' AS network, h.*
+			FROM hdb_hosts_`+s.network+` h
+			WHERE h.blocked = FALSE
+			AND (`+cond+`)
+		`)
+		queryArgs = append(queryArgs, args...)
+	}
+	queryArgs = append(queryArgs, limit, offset)
+
+	rows, err := hdb.s.db.Query(`
+		SELECT network, public_key
+		FROM (`+strings.Join(queries, "UNION ALL")+`) AS hosts
+		ORDER BY `+order+`
+		LIMIT ? OFFSET ?
+	`, queryArgs...)
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't query hosts")
+	}
+	defer rows.Close()
+
+	var hosts []HostDBEntry
+	for rows.Next() {
+		var network string
+		pk := make([]byte, 32)
+		if err := rows.Scan(&network, &pk); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode host")
+		}
+		s := hdb.s
+		if network == "zen" {
+			s = hdb.sZen
+		}
+		if host, exists := s.hostEntry(types.PublicKey(pk)); exists {
+			hosts = append(hosts, host)
+		}
+	}
+
+	return hosts, nil
+}
+
+// hostEntry returns a copy of the host entry.
+func (s *hostDBStore) hostEntry(pk types.PublicKey) (HostDBEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	host, exists := s.hosts[pk]
+	if !exists {
+		return HostDBEntry{}, false
+	}
+	entry := *host
+	entry.ActiveHosts = s.activeHostsInSubnet(host.IPNets)
+	return entry, true
+}
+
+// HostsByMinUptime returns the hosts whose uptime is at least pct percent
+// of the total time they have been scanned.
+func (hdb *HostDB) HostsByMinUptime(pct float64, offset, limit int) []HostDBEntry {
+	hosts, err := hdb.queryHosts(hdb.stores(""), `
+		uptime + downtime > 0
+		AND uptime * 100 >= ? * (uptime + downtime)
+	`, []interface{}{pct}, "network, id", offset, limit)
+	if err != nil {
+		hdb.log.Error("couldn't query hosts by uptime", zap.Error(err))
+		return nil
+	}
+	return hosts
+}
+
+// HostsByMinDuration returns the hosts that accept contracts and whose
+// maximum contract duration is at least the given number of blocks.
+func (hdb *HostDB) HostsByMinDuration(blocks uint64, offset, limit int) []HostDBEntry {
+	hosts, err := hdb.queryHosts(hdb.stores(""), `
+		accepting_contracts = TRUE
+		AND max_duration >= ?
+	`, []interface{}{blocks}, "network, id", offset, limit)
+	if err != nil {
+		hdb.log.Error("couldn't query hosts by duration", zap.Error(err))
+		return nil
+	}
+	return hosts
+}
+
+// HostsByMinThroughput returns the hosts whose last benchmark was
+// successful, was run within the last 7 days, and measured at least
+// the given upload and download speeds in MB/s.
+func (hdb *HostDB) HostsByMinThroughput(upMBps, downMBps float64, offset, limit int) []HostDBEntry {
+	hosts, err := hdb.queryHosts(hdb.stores(""), `
+		benchmarked_at >= ?
+		AND upload_speed > 0
+		AND upload_speed >= ?
+		AND download_speed > 0
+		AND download_speed >= ?
+	`, []interface{}{time.Now().Add(-failingBenchmarksWindow).Unix(), upMBps * 1e6, downMBps * 1e6}, "network, id", offset, limit)
+	if err != nil {
+		hdb.log.Error("couldn't query hosts by throughput", zap.Error(err))
+		return nil
+	}
+	return hosts
+}
+
+// KeyLineage returns the keys the host used at the same address before,
+// most recent first. The lineage is followed across several resets.
+func (hdb *HostDB) KeyLineage(pk types.PublicKey) []types.PublicKey {
+	s, exists := hdb.hostStore(pk)
+	if !exists {
+		return nil
+	}
+	var lineage []types.PublicKey
+	seen := map[types.PublicKey]bool{pk: true}
+	for {
+		host, exists := s.hostEntry(pk)
+		if !exists || len(host.PreviousKeys) == 0 || seen[host.PreviousKeys[0]] {
+			return lineage
+		}
+		pk = host.PreviousKeys[0]
+		seen[pk] = true
+		lineage = append(lineage, pk)
+	}
+}
+
+// SetFavorite adds the host to or removes it from the favorites.
+func (hdb *HostDB) SetFavorite(pk types.PublicKey, fav bool) error {
+	s, exists := hdb.hostStore(pk)
+	if !exists {
+		return ErrHostNotFound
+	}
+	return s.setFavorite(pk, fav)
+}
+
+// Favorites returns the favorite hosts of both networks.
+func (hdb *HostDB) Favorites() []HostDBEntry {
+	hosts, err := hdb.queryHosts(hdb.stores(""), "favorite = TRUE", nil, "network, id", 0, math.MaxInt64)
+	if err != nil {
+		hdb.log.Error("couldn't query favorite hosts", zap.Error(err))
+		return nil
+	}
+	return hosts
+}
+
+// FindHosts returns the cheapest online hosts satisfying the constraints,
+// sorted by the storage price.
+func (hdb *HostDB) FindHosts(req HostQuery) []HostDBEntry {
+	network := req.Network
+	if network == "" {
+		network = "mainnet"
+	}
+	hosts, err := hdb.queryHosts(hdb.stores(network), `
+		remaining_storage >= ?
+		AND uptime * 100 >= ? * (uptime + downtime)
+		AND (accepting_contracts = TRUE OR ? = FALSE)
+	`, []interface{}{req.MinRemainingStorage, req.MinUptime, req.AcceptingContracts}, "storage_price, id", 0, math.MaxInt64)
+	if err != nil {
+		hdb.log.Error("couldn't find hosts", zap.Error(err))
+		return nil
+	}
+
+	// Enforce the subnet diversity.
+	var excluded []*net.IPNet
+	for _, subnet := range req.ExcludeSubnets {
+		_, ipNet, err := net.ParseCIDR(subnet)
+		if err != nil {
+			hdb.log.Error("couldn't parse subnet", zap.String("subnet", subnet), zap.Error(err))
+			continue
+		}
+		excluded = append(excluded, ipNet)
+	}
+	used := make(map[string]struct{})
+	var result []HostDBEntry
+outer:
+	for _, host := range hosts {
+		if !onlineWithin(&host, req.MaxScanAge) {
+			continue
+		}
+		for _, ipNet := range host.IPNets {
+			if _, exists := used[ipNet]; exists {
+				continue outer
+			}
+			_, n, err := net.ParseCIDR(ipNet)
+			if err != nil {
+				continue
+			}
+			for _, e := range excluded {
+				if e.Contains(n.IP) || n.Contains(e.IP) {
+					continue outer
+				}
+			}
+		}
+		for _, ipNet := range host.IPNets {
+			used[ipNet] = struct{}{}
+		}
+		result = append(result, host)
+		if req.Limit > 0 && len(result) >= req.Limit {
+			break
+		}
+	}
+
+	return result
+}
+
+// QueryHosts returns a page of the hosts matching all constraints of the
+// filter, together with the total number of the matching hosts. The hosts
+// carry no tags; the favorites can be selected instead.
+func (hdb *HostDB) QueryHosts(q HostFilter) ([]HostDBEntry, int) {
+	network := q.Network
+	if network == "" {
+		network = "mainnet"
+	}
+	column, ok := hostSortFields[q.SortBy]
+	if !ok {
+		hdb.log.Error("unknown sort field", zap.String("sortBy", q.SortBy))
+		return nil, 0
+	}
+	order := column + " ASC, network, id"
+	if q.Descending {
+		order = column + " DESC, network, id"
+	}
+
+	conds := []string{"TRUE"}
+	var args []interface{}
+	if q.MinRemainingStorage > 0 {
+		conds = append(conds, "remaining_storage >= ?")
+		args = append(args, q.MinRemainingStorage)
+	}
+	if q.MinUptime > 0 {
+		conds = append(conds, "uptime * 100 >= ? * (uptime + downtime)")
+		args = append(args, q.MinUptime)
+	}
+	if q.AcceptingContracts {
+		conds = append(conds, "accepting_contracts = TRUE")
+	}
+	if q.Favorite {
+		conds = append(conds, "favorite = TRUE")
+	}
+	if q.MaxStoragePrice > 0 {
+		conds = append(conds, "storage_price <= ?")
+		args = append(args, q.MaxStoragePrice)
+	}
+	if q.MinUploadSpeed > 0 || q.MinDownloadSpeed > 0 {
+		conds = append(conds, "benchmarked_at >= ? AND upload_speed >= ? AND download_speed >= ?")
+		args = append(args, time.Now().Add(-failingBenchmarksWindow).Unix(), q.MinUploadSpeed*1e6, q.MinDownloadSpeed*1e6)
+	}
+
+	hosts, err := hdb.queryHosts(hdb.stores(network), strings.Join(conds, " AND "), args, order, 0, math.MaxInt64)
+	if err != nil {
+		hdb.log.Error("couldn't query hosts", zap.Error(err))
+		return nil, 0
+	}
+
+	// The online status is not stored in the database, so it is
+	// filtered here.
+	var matching []HostDBEntry
+	for _, host := range hosts {
+		if q.Online && !onlineWithin(&host, q.MaxScanAge) {
+			continue
+		}
+		matching = append(matching, host)
+	}
+
+	total := len(matching)
+	if q.Offset > 0 {
+		if q.Offset >= len(matching) {
+			return nil, total
+		}
+		matching = matching[q.Offset:]
+	}
+	if q.Limit > 0 && len(matching) > q.Limit {
+		matching = matching[:q.Limit]
+	}
+	return matching, total
+}
@@ -2,25 +2,86 @@ package hostdb
 
 import (
 	"context"
+	"errors"
 	"math"
-	"strings"
+	"net"
+	"sort"
+	"strconv"
 	"time"
 
+	siasync "github.com/mike76-dev/hostscore/internal/sync"
 	"github.com/mike76-dev/hostscore/internal/utils"
 	"github.com/mike76-dev/hostscore/rhp"
 	rhpv2 "go.sia.tech/core/rhp/v2"
 	rhpv3 "go.sia.tech/core/rhp/v3"
+	"go.sia.tech/core/types"
 	"go.uber.org/zap"
+	"lukechampine.com/frand"
 )
 
 const (
-	scanInterval        = 30 * time.Minute
-	scanBatchSize       = 20
-	maxScanThreads      = 1000
-	maxBenchmarkThreads = 20
-	minScans            = 25
+	scanInterval         = 30 * time.Minute
+	scanBatchSize        = 20
+	maxScanThreads       = 1000
+	maxBenchmarkThreads  = 20
+	minScans             = 25
+	medianPricesInterval = 10 * time.Minute
+
+	defaultScanTimeout           = 30 * time.Second
+	maxScanTimeout               = 2 * time.Minute
+	defaultScanTimeoutPercentile = 0.5
+	defaultScanTimeoutMultiplier = 5
+
+	// ipChangeRescanWindow is how long after an IP change the host is
+	// scanned more often.
+	ipChangeRescanWindow          = 24 * time.Hour
+	defaultIPChangeScanMultiplier = 0.25
+
+	// The sync wait is polled with an exponential backoff, starting at
+	// minSyncWaitInterval, and the progress is logged every
+	// syncProgressInterval.
+	minSyncWaitInterval     = time.Second
+	defaultSyncWaitInterval = time.Minute
+	syncProgressInterval    = 5 * time.Minute
 )
 
+// errPrivateAddress is returned when the host resolves only to private
+// addresses.
+var errPrivateAddress = errors.New("host resolves only to private addresses")
+
+// errSiaMuxOnRHP2Port is returned when the host advertises its RHP2 port
+// as the SiaMux port, and none of the alternative ports work.
+var errSiaMuxOnRHP2Port = errors.New("SiaMux address points to the RHP2 port")
+
+// recordScanLatency records the latency of a successful scan. Once
+// enough latencies are collected, the scan timeout is calibrated. The
+// latencies only cover the RHP2 handshake, so the timeout is never set
+// below the default, which leaves room for the rest of the scan.
+func (hdb *HostDB) recordScanLatency(latency time.Duration) {
+	hdb.mu.Lock()
+	defer hdb.mu.Unlock()
+	if len(hdb.initialScanLatencies) >= minScans {
+		return
+	}
+	hdb.initialScanLatencies = append(hdb.initialScanLatencies, latency)
+	if len(hdb.initialScanLatencies) < minScans {
+		return
+	}
+
+	latencies := append([]time.Duration(nil), hdb.initialScanLatencies...)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	i := int(hdb.scanTimeoutPercentile * float64(len(latencies)-1))
+	timeout := time.Duration(float64(latencies[i]) * hdb.scanTimeoutMultiplier)
+	if timeout < defaultScanTimeout {
+		timeout = defaultScanTimeout
+	}
+	if timeout > maxScanTimeout {
+		timeout = maxScanTimeout
+	}
+	hdb.scanTimeout = timeout
+	hdb.log.Info("scan timeout calibrated", zap.Duration("timeout", timeout))
+}
+
 // queueScan will add a host to the queue to be scanned.
 func (hdb *HostDB) queueScan(host *HostDBEntry) {
 	if host.Network != "mainnet" && host.Network != "zen" {
@@ -34,13 +95,18 @@ func (hdb *HostDB) queueScan(host *HostDBEntry) {
 		return
 	}
 	// Put the entry in the scan list.
-	var interval time.Duration
+	s := hdb.s
 	if host.Network == "zen" {
-		interval = hdb.sZen.calculateScanInterval(host)
-	} else {
-		interval = hdb.s.calculateScanInterval(host)
+		s = hdb.sZen
+	}
+	toBenchmark := len(host.ScanHistory) > 0 && time.Since(lastRefresh(host)) < s.calculateScanInterval(host)
+	// A host that failed its last scan is not worth benchmarking, even if
+	// an earlier scan succeeded. It will be queued again once it is due
+	// for a scan.
+	if toBenchmark && !s.benchmarkDue(host) {
+		hdb.mu.Unlock()
+		return
 	}
-	toBenchmark := len(host.ScanHistory) > 0 && time.Since(host.ScanHistory[len(host.ScanHistory)-1].Timestamp) < interval
 	hdb.scanMap[host.PublicKey] = toBenchmark
 	if toBenchmark {
 		hdb.benchmarkList = append(hdb.benchmarkList, host)
@@ -50,6 +116,39 @@ func (hdb *HostDB) queueScan(host *HostDBEntry) {
 	hdb.mu.Unlock()
 }
 
+// dequeue removes the host from the scan and the benchmark queues.
+func (hdb *HostDB) dequeue(pk types.PublicKey) {
+	hdb.mu.Lock()
+	defer hdb.mu.Unlock()
+	filter := func(list []*HostDBEntry) []*HostDBEntry {
+		var filtered []*HostDBEntry
+		for _, host := range list {
+			if host.PublicKey != pk {
+				filtered = append(filtered, host)
+			}
+		}
+		return filtered
+	}
+	hdb.scanList = filter(hdb.scanList)
+	hdb.benchmarkList = filter(hdb.benchmarkList)
+	if _, inFlight := hdb.inFlight[pk]; !inFlight {
+		delete(hdb.scanMap, pk)
+	}
+}
+
+// InFlightScans returns the keys of the hosts that are being scanned or
+// benchmarked right now. Unlike the scan map, it does not include the
+// hosts that are only waiting in the queue.
+func (hdb *HostDB) InFlightScans() []types.PublicKey {
+	hdb.mu.Lock()
+	defer hdb.mu.Unlock()
+	keys := make([]types.PublicKey, 0, len(hdb.inFlight))
+	for pk := range hdb.inFlight {
+		keys = append(keys, pk)
+	}
+	return keys
+}
+
 // scanHost will connect to a host and grab the settings and the price
 // table as well as adjust the info.
 func (hdb *HostDB) scanHost(host *HostDBEntry) {
@@ -60,24 +159,37 @@ func (hdb *HostDB) scanHost(host *HostDBEntry) {
 	// Resolve the host's used subnets and update the timestamp if they
 	// changed. We only update the timestamp if resolving the ipNets was
 	// successful.
+	lookupStart := time.Now()
 	ipNets, err := utils.LookupIPNets(host.NetAddress)
+	hdb.recordDNSLookup(time.Since(lookupStart))
 	if err == nil && !utils.EqualIPNets(ipNets, host.IPNets) {
 		host.IPNets = ipNets
 		host.LastIPChange = time.Now()
 	}
+	host.PrivateAddress = utils.IsPrivateIPNets(host.IPNets)
 
 	// Update historic interactions of the host if necessary.
 	hdb.updateHostHistoricInteractions(host)
 
 	var settings rhpv2.HostSettings
 	var pt rhpv3.HostPriceTable
-	var latency time.Duration
+	var latency, rhp3TTFB, ptFetch time.Duration
+	var siamuxPort string
 	var success bool
 	var errMsg string
 	var start time.Time
 	err = func() error {
+		// Don't attempt to connect to a host that can't be reachable.
+		if host.PrivateAddress && !hdb.scanPrivateAddresses {
+			start = time.Now()
+			return errPrivateAddress
+		}
+
 		// Create a context and set up its cancelling.
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		hdb.mu.Lock()
+		timeout := hdb.scanTimeout
+		hdb.mu.Unlock()
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
 		connCloseChan := make(chan struct{})
 		go func() {
 			select {
@@ -90,7 +202,7 @@ func (hdb *HostDB) scanHost(host *HostDBEntry) {
 
 		// Initiate RHP2 protocol.
 		start = time.Now()
-		err := rhp.WithTransportV2(ctx, host.NetAddress, host.PublicKey, func(t *rhpv2.Transport) error {
+		remoteIP, err := rhp.WithTransportV2Remote(ctx, host.NetAddress, host.PublicKey, func(t *rhpv2.Transport) error {
 			var err error
 			settings, err = rhp.RPCSettings(ctx, t)
 			return err
@@ -99,27 +211,88 @@ func (hdb *HostDB) scanHost(host *HostDBEntry) {
 		if err == nil {
 			success = true
 
-			// Initiate RHP3 protocol.
-			err = rhp.WithTransportV3(ctx, settings.SiamuxAddr(), host.PublicKey, func(t *rhpv3.Transport) error {
-				var err error
-				pt, err = rhp.RPCPriceTable(ctx, t, func(pt rhpv3.HostPriceTable) (rhpv3.PaymentMethod, error) {
-					return nil, nil
+			// Initiate RHP3 protocol. If the SiaMux runs on the same
+			// host as RHP2, connect to the address resolved in the RHP2
+			// phase. This saves a name lookup per scan, which adds up
+			// with slow or uncached resolvers. If the advertised SiaMux
+			// port is unreachable, try the common alternatives.
+			h, _, _ := net.SplitHostPort(settings.NetAddress)
+			rhp2Host, rhp2Port, _ := net.SplitHostPort(host.NetAddress)
+
+			// Some hosts advertise their RHP2 port as the SiaMux port.
+			// Connecting there can only fail, so skip it and try the
+			// alternatives.
+			ports := siamuxPorts(settings)
+			misconfigured := h == rhp2Host && settings.SiaMuxPort == rhp2Port
+			if misconfigured {
+				ports = ports[1:]
+				if len(ports) == 0 {
+					return errSiaMuxOnRHP2Port
+				}
+			}
+
+			if hdb.reuseAddress && remoteIP != "" && h == rhp2Host {
+				h = remoteIP
+			}
+			for _, port := range ports {
+				rhp3Start := time.Now()
+				err = rhp.WithTransportV3(ctx, net.JoinHostPort(h, port), host.PublicKey, func(t *rhpv3.Transport) error {
+					rhp3TTFB = time.Since(rhp3Start)
+					var err error
+					pt, err = rhp.RPCPriceTable(ctx, t, func(pt rhpv3.HostPriceTable) (rhpv3.PaymentMethod, error) {
+						return nil, nil
+					})
+					if err == nil {
+						ptFetch = time.Since(rhp3Start)
+					}
+					return err
 				})
-				return err
-			})
+				if err == nil {
+					siamuxPort = port
+				}
+				if !isDialError(err) || ctx.Err() != nil {
+					break
+				}
+			}
+			if err != nil && misconfigured {
+				err = utils.AddContext(err, errSiaMuxOnRHP2Port.Error())
+			}
 		}
 
 		return err
 	}()
-	if err != nil && strings.Contains(err.Error(), "canceled") {
+	if errors.Is(err, context.Canceled) {
 		// Shutting down.
 		return
 	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = ErrScanTimeout
+	}
+	hdb.mu.Lock()
+	validate := hdb.scanValidator
+	hdb.mu.Unlock()
+	if err == nil && validate != nil {
+		if verr := validate(HostScan{
+			Timestamp:  start,
+			Success:    success,
+			Latency:    latency,
+			Settings:   settings,
+			PriceTable: pt,
+
+			RHP3TTFB:        rhp3TTFB,
+			PriceTableFetch: ptFetch,
+			SiaMuxPort:      siamuxPort,
+		}); verr != nil {
+			err = utils.AddContext(verr, "scan rejected")
+			success = false
+		}
+	}
 	if err == nil {
-		hdb.IncrementSuccessfulInteractions(host)
+		hdb.IncrementSuccessfulInteractions(host, scanWeight)
+		hdb.recordScanLatency(latency)
 	} else {
 		errMsg = err.Error()
-		hdb.IncrementFailedInteractions(host)
+		hdb.IncrementFailedInteractions(host, scanWeight)
 	}
 
 	scan := HostScan{
@@ -129,6 +302,19 @@ func (hdb *HostDB) scanHost(host *HostDBEntry) {
 		Error:      errMsg,
 		Settings:   settings,
 		PriceTable: pt,
+
+		RHP3TTFB:        rhp3TTFB,
+		PriceTableFetch: ptFetch,
+		SiaMuxPort:      siamuxPort,
+	}
+	if success {
+		scan.SettingsWarnings = ValidateSettings(settings)
+	}
+	if !success {
+		scan.EverOnline = !host.LastSeen.IsZero()
+	}
+	if hdb.signScans {
+		hdb.signScan(host, &scan)
 	}
 
 	// Update the host database.
@@ -139,13 +325,231 @@ func (hdb *HostDB) scanHost(host *HostDBEntry) {
 	}
 	if err != nil {
 		hdb.log.Error("couldn't update scan history", zap.Error(err))
+		return
+	}
+
+	hdb.publishScan(host, scan)
+}
+
+// fallbackSiaMuxPorts are the default SiaMux ports.
+var fallbackSiaMuxPorts = []string{"9983"}
+
+// siamuxPorts returns the ports to try when connecting to the SiaMux of
+// the host: the advertised port first, followed by the RHP2 port plus one
+// and the common defaults.
+func siamuxPorts(settings rhpv2.HostSettings) []string {
+	ports := []string{settings.SiaMuxPort}
+	if _, p, err := net.SplitHostPort(settings.NetAddress); err == nil {
+		if n, err := strconv.Atoi(p); err == nil {
+			ports = append(ports, strconv.Itoa(n+1))
+		}
+	}
+	ports = append(ports, fallbackSiaMuxPorts...)
+
+	// Remove the duplicates.
+	seen := make(map[string]bool)
+	var unique []string
+	for _, port := range ports {
+		if port != "" && !seen[port] {
+			seen[port] = true
+			unique = append(unique, port)
+		}
+	}
+	return unique
+}
+
+// isDialError returns true if the error occurred while dialing the host.
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// SetScanInterval overrides the scan interval of the host. A zero
+// duration restores the default behavior.
+func (hdb *HostDB) SetScanInterval(pk types.PublicKey, d time.Duration) error {
+	if d < 0 {
+		return errors.New("negative scan interval")
+	}
+	s, exists := hdb.hostStore(pk)
+	if !exists {
+		return ErrHostNotFound
+	}
+	return s.setScanInterval(pk, d)
+}
+
+// SetPriority sets the scan priority of the host. Hosts with a higher
+// priority are scanned first within a scan cycle. The default is zero.
+func (hdb *HostDB) SetPriority(pk types.PublicKey, priority int) error {
+	s, exists := hdb.hostStore(pk)
+	if !exists {
+		return ErrHostNotFound
+	}
+	return s.setPriority(pk, priority)
+}
+
+// PingHost establishes an RHP2 transport with the host and returns the
+// time it took to connect and complete the handshake. No RPCs are called,
+// which makes it much cheaper than a full scan. The result is not
+// recorded in the scan history, but it counts towards the interactions
+// with a lower weight than a full scan.
+func (hdb *HostDB) PingHost(pk types.PublicKey) (time.Duration, error) {
+	if err := hdb.tg.Add(); err != nil {
+		return 0, err
+	}
+	defer hdb.tg.Done()
+
+	s, exists := hdb.hostStore(pk)
+	if !exists {
+		return 0, ErrHostNotFound
+	}
+	host, exists := s.hostEntry(pk)
+	if !exists {
+		return 0, ErrHostNotFound
+	}
+	if host.PrivateAddress && !hdb.scanPrivateAddresses {
+		return 0, errPrivateAddress
+	}
+
+	hdb.mu.Lock()
+	timeout := hdb.scanTimeout
+	hdb.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-hdb.tg.StopChan():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	start := time.Now()
+	err := rhp.WithTransportV2(ctx, host.NetAddress, host.PublicKey, func(*rhpv2.Transport) error {
+		return nil
+	})
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = ErrScanTimeout
+	}
+	latency := time.Since(start)
+	if uerr := s.recordPing(pk, err == nil); uerr != nil {
+		hdb.log.Error("couldn't record ping", zap.Stringer("host", pk), zap.Error(uerr))
+	}
+	if err != nil {
+		return 0, err
+	}
+	return latency, nil
+}
+
+// VerifyReachable checks if a host is listening on the given address by
+// performing the RHP2 handshake, and returns the time it took. The host's
+// public key is not known, so the handshake signature is not verified. The
+// database is not touched. An error is only returned if the address is
+// invalid, the context is canceled, or the HostDB is shutting down.
+func (hdb *HostDB) VerifyReachable(ctx context.Context, netAddr string) (bool, time.Duration, error) {
+	if err := hdb.tg.Add(); err != nil {
+		return false, 0, err
+	}
+	defer hdb.tg.Done()
+
+	if err := utils.IsValid(netAddr); err != nil {
+		return false, 0, utils.AddContext(err, "invalid address")
 	}
 
-	// Delete the host from scanMap.
 	hdb.mu.Lock()
-	delete(hdb.scanMap, host.PublicKey)
-	hdb.scanThreads--
+	timeout := hdb.scanTimeout
 	hdb.mu.Unlock()
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-hdb.tg.StopChan():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	start := time.Now()
+	err := rhp.HandshakeV2(ctx, netAddr)
+	latency := time.Since(start)
+	select {
+	case <-hdb.tg.StopChan():
+		return false, 0, siasync.ErrStopped
+	default:
+	}
+	if parent.Err() != nil {
+		return false, 0, parent.Err()
+	}
+	if err != nil {
+		return false, 0, nil
+	}
+	return true, latency, nil
+}
+
+// SetScanValidator sets a function that is called after each successful
+// scan. If it returns an error, the scan is recorded as failed with the
+// error as the reason. A nil function removes the validator.
+func (hdb *HostDB) SetScanValidator(fn func(HostScan) error) {
+	hdb.mu.Lock()
+	defer hdb.mu.Unlock()
+	hdb.scanValidator = fn
+}
+
+// ActiveScans returns the number of scans currently in progress.
+func (hdb *HostDB) ActiveScans() int {
+	hdb.mu.Lock()
+	defer hdb.mu.Unlock()
+	return hdb.scanThreads
+}
+
+// scanWorker scans the hosts from the scan queue with the given function
+// until HostDB is stopped.
+func (hdb *HostDB) scanWorker(scan func(*HostDBEntry)) {
+	if err := hdb.tg.Add(); err != nil {
+		return
+	}
+	defer hdb.tg.Done()
+
+	for {
+		select {
+		case <-hdb.tg.StopChan():
+			return
+		case host := <-hdb.scanQueue:
+			hdb.mu.Lock()
+			hdb.scanThreads++
+			hdb.inFlight[host.PublicKey] = struct{}{}
+			hdb.mu.Unlock()
+			start := time.Now()
+			scan(host)
+			hdb.recordScan(time.Since(start))
+
+			// Release the slot no matter how the scan ended.
+			hdb.mu.Lock()
+			delete(hdb.scanMap, host.PublicKey)
+			delete(hdb.inFlight, host.PublicKey)
+			hdb.scanThreads--
+			hdb.mu.Unlock()
+		}
+	}
+}
+
+// jitter randomizes the duration by up to 20% in either direction.
+func jitter(d time.Duration) time.Duration {
+	return time.Duration(float64(d) * (0.8 + 0.4*frand.Float64()))
+}
+
+// logSyncProgress logs the sync state of both networks.
+func (hdb *HostDB) logSyncProgress() {
+	tip := hdb.cm.TipState()
+	tipZen := hdb.cmZen.TipState()
+	hdb.log.Info("waiting for the blockchain to sync",
+		zap.Uint64("height", tip.Index.Height),
+		zap.Duration("behind", time.Since(tip.PrevTimestamps[0])),
+		zap.Int("peers", len(hdb.syncer.Peers())),
+		zap.Uint64("heightZen", tipZen.Index.Height),
+		zap.Duration("behindZen", time.Since(tipZen.PrevTimestamps[0])),
+		zap.Int("peersZen", len(hdb.syncerZen.Peers())),
+	)
 }
 
 // scanHosts is an ongoing function which will scan the full set of hosts
@@ -157,46 +561,67 @@ func (hdb *HostDB) scanHosts() {
 	}
 	defer hdb.tg.Done()
 
+	interval := minSyncWaitInterval
+	lastProgress := time.Now()
 	for {
-		if hdb.synced("mainnet") || hdb.synced("zen") {
+		if hdb.scannable("mainnet") || hdb.scannable("zen") {
 			break
 		}
+		if time.Since(lastProgress) >= syncProgressInterval {
+			hdb.logSyncProgress()
+			lastProgress = time.Now()
+		}
 		select {
 		case <-hdb.tg.StopChan():
 			return
-		case <-time.After(time.Second):
+		case <-time.After(jitter(interval)):
+		}
+		interval *= 2
+		if interval > hdb.syncWaitInterval {
+			interval = hdb.syncWaitInterval
 		}
 	}
 
+	// Start the scan workers.
+	for i := 0; i < maxScanThreads; i++ {
+		go hdb.scanWorker(hdb.scanHost)
+	}
+
 	for {
-		if hdb.synced("mainnet") {
+		if hdb.scannable("mainnet") {
 			hdb.s.getHostsForScan()
 		}
-		if hdb.synced("zen") {
+		if hdb.scannable("zen") {
 			hdb.sZen.getHostsForScan()
 		}
 
+		hdb.mu.Lock()
+		refresh := time.Since(hdb.medianPrices.UpdatedAt) >= medianPricesInterval
+		hdb.mu.Unlock()
+		if refresh && hdb.synced("mainnet") {
+			hdb.updateMedianPrices()
+		}
+
+		// Hand the hosts over to the scan workers until all of them
+		// are busy.
+		hdb.mu.Lock()
+	dispatch:
 		for len(hdb.scanList) > 0 {
-			hdb.mu.Lock()
-			if hdb.scanThreads < maxScanThreads {
-				hdb.scanThreads++
-				batchSize := scanBatchSize
-				if batchSize > len(hdb.scanList) {
-					batchSize = len(hdb.scanList)
+			// The key is computed before the host is handed over, because
+			// the worker may modify it right away.
+			key := subnetKey(hdb.scanList[0])
+			select {
+			case hdb.scanQueue <- hdb.scanList[0]:
+				if key != hdb.lastScanSubnet {
+					hdb.subnetSwitches++
+					hdb.lastScanSubnet = key
 				}
-				list := hdb.scanList[:batchSize]
-				hdb.scanList = hdb.scanList[batchSize:]
-				hdb.mu.Unlock()
-				go func() {
-					for _, entry := range list {
-						hdb.scanHost(entry)
-					}
-				}()
-			} else {
-				hdb.mu.Unlock()
-				break
+				hdb.scanList = hdb.scanList[1:]
+			default:
+				break dispatch
 			}
 		}
+		hdb.mu.Unlock()
 
 		for len(hdb.benchmarkList) > 0 {
 			hdb.mu.Lock()
@@ -204,8 +629,9 @@ func (hdb *HostDB) scanHosts() {
 				hdb.benchmarkThreads++
 				entry := hdb.benchmarkList[0]
 				hdb.benchmarkList = hdb.benchmarkList[1:]
+				hdb.inFlight[entry.PublicKey] = struct{}{}
 				hdb.mu.Unlock()
-				go hdb.benchmarkHost(entry)
+				go hdb.runBenchmark(entry, hdb.benchmarkHost)
 			} else {
 				hdb.mu.Unlock()
 				break
@@ -220,9 +646,81 @@ func (hdb *HostDB) scanHosts() {
 	}
 }
 
+// runBenchmark benchmarks the host with the given function and releases
+// the benchmark slot taken for it.
+func (hdb *HostDB) runBenchmark(host *HostDBEntry, benchmark func(*HostDBEntry)) {
+	// Release the slot on every return path. The mutex is not held
+	// here, so it needs to be acquired.
+	defer func() {
+		hdb.mu.Lock()
+		delete(hdb.scanMap, host.PublicKey)
+		delete(hdb.inFlight, host.PublicKey)
+		hdb.benchmarkThreads--
+		hdb.mu.Unlock()
+		hdb.requeueAfterBenchmark(host)
+	}()
+	if err := hdb.tg.Add(); err != nil {
+		return
+	}
+	defer hdb.tg.Done()
+	start := time.Now()
+	benchmark(host)
+	hdb.recordBenchmark(time.Since(start))
+}
+
+// requeueAfterBenchmark queues the host for a scan if the scan became due
+// while the host was waiting for or running a benchmark. A host is not
+// scanned while it is being benchmarked, so this keeps its availability
+// data from going stale.
+func (hdb *HostDB) requeueAfterBenchmark(host *HostDBEntry) {
+	s := hdb.s
+	if host.Network == "zen" {
+		s = hdb.sZen
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if host.Blocked || len(host.ScanHistory) == 0 {
+		return
+	}
+	if time.Since(lastRefresh(host)) < s.calculateScanInterval(host) {
+		return
+	}
+	hdb.queueScan(host)
+}
+
+// lastRefresh returns when the host's pricing was last refreshed. This is
+// normally the time of the last scan, but a benchmark that fetched a fresh
+// price table counts as well, so that the host is not scanned again right
+// after being benchmarked. The settings are not refetched by a benchmark,
+// so this defers the next scan by at most one scan interval.
+func lastRefresh(host *HostDBEntry) time.Time {
+	var t time.Time
+	if len(host.ScanHistory) > 0 {
+		t = host.ScanHistory[len(host.ScanHistory)-1].Timestamp
+	}
+	if host.BenchmarkRefresh.After(t) {
+		t = host.BenchmarkRefresh
+	}
+	return t
+}
+
 // calculateScanInterval calculates a scan interval depending on how long ago
-// the host was seen online.
+// the host was seen online. The interval is shortened if the host's IP
+// address has changed recently.
 func (s *hostDBStore) calculateScanInterval(host *HostDBEntry) time.Duration {
+	if host.ScanInterval > 0 {
+		return host.ScanInterval
+	}
+	interval := s.baseScanInterval(host)
+	if interval != math.MaxInt64 && time.Since(host.LastIPChange) < ipChangeRescanWindow {
+		interval = time.Duration(float64(interval) * s.hdb.ipChangeScanMultiplier)
+	}
+	return interval
+}
+
+// baseScanInterval calculates a scan interval depending on how long ago
+// the host was seen online.
+func (s *hostDBStore) baseScanInterval(host *HostDBEntry) time.Duration {
 	if host.LastSeen.IsZero() || len(host.ScanHistory) == 0 {
 		return scanInterval // 30 minutes
 	}
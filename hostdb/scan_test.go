@@ -0,0 +1,130 @@
+package hostdb
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/types"
+	"lukechampine.com/frand"
+)
+
+// newTestHostDB returns a HostDB with just enough state for the scan
+// and benchmark workers to run.
+func newTestHostDB() *HostDB {
+	return &HostDB{
+		s:         &hostDBStore{network: "mainnet", hosts: make(map[types.PublicKey]*HostDBEntry)},
+		sZen:      &hostDBStore{network: "zen", hosts: make(map[types.PublicKey]*HostDBEntry)},
+		scanQueue: make(chan *HostDBEntry, scanBatchSize),
+		scanMap:   make(map[types.PublicKey]bool),
+		inFlight:  make(map[types.PublicKey]struct{}),
+	}
+}
+
+// randomHost returns a Mainnet host with a random public key.
+func randomHost() *HostDBEntry {
+	host := &HostDBEntry{Network: "mainnet"}
+	frand.Read(host.PublicKey[:])
+	return host
+}
+
+// TestScanWorkersBounded checks that the worker pool never runs more than
+// maxScanThreads scans at once.
+func TestScanWorkersBounded(t *testing.T) {
+	hdb := newTestHostDB()
+
+	var mu sync.Mutex
+	var running, peak int
+	var wg sync.WaitGroup
+	scan := func(*HostDBEntry) {
+		defer wg.Done()
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+	}
+
+	for i := 0; i < maxScanThreads; i++ {
+		go hdb.scanWorker(scan)
+	}
+	n := 3 * maxScanThreads
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		hdb.scanQueue <- randomHost()
+	}
+	wg.Wait()
+	hdb.tg.Stop()
+
+	if peak > maxScanThreads {
+		t.Fatalf("%d scans ran concurrently, expected at most %d", peak, maxScanThreads)
+	}
+	if active := hdb.ActiveScans(); active != 0 {
+		t.Fatalf("%d scans still active after stopping", active)
+	}
+}
+
+// TestBenchmarkAfterStop checks that a benchmark goroutine started during
+// the shutdown releases its slot without touching the mutex it doesn't
+// hold.
+func TestBenchmarkAfterStop(t *testing.T) {
+	hdb := newTestHostDB()
+	hdb.tg.Stop()
+
+	host := randomHost()
+	hdb.mu.Lock()
+	hdb.benchmarkThreads++
+	hdb.scanMap[host.PublicKey] = true
+	hdb.inFlight[host.PublicKey] = struct{}{}
+	hdb.mu.Unlock()
+
+	hdb.runBenchmark(host, func(*HostDBEntry) {
+		t.Fatal("host benchmarked after stopping")
+	})
+
+	// The mutex must be unlocked and usable.
+	hdb.mu.Lock()
+	defer hdb.mu.Unlock()
+	if hdb.benchmarkThreads != 0 {
+		t.Fatalf("expected no benchmark threads, got %d", hdb.benchmarkThreads)
+	}
+	if len(hdb.scanMap) != 0 || len(hdb.inFlight) != 0 {
+		t.Fatal("host not released after stopping")
+	}
+}
+
+// TestSlotsReleasedAfterStop checks that repeatedly failing tg.Add never
+// leaks a scan or a benchmark slot.
+func TestSlotsReleasedAfterStop(t *testing.T) {
+	hdb := newTestHostDB()
+	hdb.tg.Stop()
+
+	for i := 0; i < 100; i++ {
+		hdb.scanQueue <- randomHost()
+		hdb.scanWorker(func(*HostDBEntry) {
+			t.Fatal("host scanned after stopping")
+		})
+		<-hdb.scanQueue
+
+		hdb.mu.Lock()
+		hdb.benchmarkThreads++
+		hdb.mu.Unlock()
+		hdb.runBenchmark(randomHost(), func(*HostDBEntry) {
+			t.Fatal("host benchmarked after stopping")
+		})
+	}
+
+	if active := hdb.ActiveScans(); active != 0 {
+		t.Fatalf("expected no active scans, got %d", active)
+	}
+	hdb.mu.Lock()
+	defer hdb.mu.Unlock()
+	if hdb.benchmarkThreads != 0 {
+		t.Fatalf("expected no benchmark threads, got %d", hdb.benchmarkThreads)
+	}
+}
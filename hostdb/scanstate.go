@@ -0,0 +1,89 @@
+package hostdb
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+// scanStateFile is the name of the file the scan state is saved to.
+const scanStateFile = "scanstate.json"
+
+// scanState is the in-memory scan state persisted across restarts.
+type scanState struct {
+	Queued      map[string][]types.PublicKey `json:"queued"`
+	Latencies   []time.Duration              `json:"latencies"`
+	ScanTimeout time.Duration                `json:"scanTimeout"`
+}
+
+// saveScanState saves the hosts waiting to be scanned or benchmarked,
+// the initial scan latencies, and the calibrated scan timeout, so that
+// they survive a restart.
+func (hdb *HostDB) saveScanState() error {
+	state := scanState{Queued: make(map[string][]types.PublicKey)}
+	hdb.mu.Lock()
+	for _, host := range append(append([]*HostDBEntry(nil), hdb.scanList...), hdb.benchmarkList...) {
+		state.Queued[host.Network] = append(state.Queued[host.Network], host.PublicKey)
+	}
+	state.Latencies = append(state.Latencies, hdb.initialScanLatencies...)
+	state.ScanTimeout = hdb.scanTimeout
+	hdb.mu.Unlock()
+
+	js, err := json.Marshal(state)
+	if err != nil {
+		return utils.AddContext(err, "couldn't encode scan state")
+	}
+	path := filepath.Join(hdb.dir, scanStateFile)
+	if err := os.WriteFile(path+"_temp", js, 0600); err != nil {
+		return utils.AddContext(err, "couldn't write scan state")
+	}
+	return os.Rename(path+"_temp", path)
+}
+
+// loadScanState restores the scan state saved by saveScanState. The
+// queued hosts are queued again.
+func (hdb *HostDB) loadScanState() error {
+	js, err := os.ReadFile(filepath.Join(hdb.dir, scanStateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return utils.AddContext(err, "couldn't read scan state")
+	}
+	var state scanState
+	if err := json.Unmarshal(js, &state); err != nil {
+		return utils.AddContext(err, "couldn't decode scan state")
+	}
+
+	hdb.mu.Lock()
+	hdb.initialScanLatencies = state.Latencies
+	if len(state.Latencies) >= minScans && state.ScanTimeout >= defaultScanTimeout && state.ScanTimeout <= maxScanTimeout {
+		hdb.scanTimeout = state.ScanTimeout
+	}
+	hdb.mu.Unlock()
+
+	for network, pks := range state.Queued {
+		if network != "mainnet" && network != "zen" {
+			continue
+		}
+		s := hdb.s
+		if network == "zen" {
+			s = hdb.sZen
+		}
+		s.mu.Lock()
+		for _, pk := range pks {
+			if host, exists := s.hosts[pk]; exists && !host.Blocked {
+				hdb.queueScan(host)
+			}
+		}
+		s.mu.Unlock()
+	}
+
+	hdb.log.Info("scan state restored", zap.Int("latencies", len(state.Latencies)), zap.Duration("timeout", hdb.scanTimeout))
+	return nil
+}
@@ -0,0 +1,170 @@
+package hostdb
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	rhpv2 "go.sia.tech/core/rhp/v2"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+var (
+	// maxSaneCollateral is the collateral per TB per month above which
+	// the host settings are considered suspicious.
+	maxSaneCollateral = types.Siacoins(1e6)
+)
+
+// ValidateSettings performs a number of sanity checks on the host
+// settings and returns the list of the problems found.
+func ValidateSettings(settings rhpv2.HostSettings) []string {
+	var warnings []string
+	if settings.MaxDuration == 0 {
+		warnings = append(warnings, "zero maximum contract duration")
+	}
+	if settings.WindowSize == 0 {
+		warnings = append(warnings, "zero proof window size")
+	}
+	if settings.SectorSize != rhpv2.SectorSize {
+		warnings = append(warnings, "unexpected sector size")
+	}
+	if settings.TotalStorage == 0 {
+		warnings = append(warnings, "zero total storage")
+	}
+	if settings.RemainingStorage > settings.TotalStorage {
+		warnings = append(warnings, "remaining storage exceeds total storage")
+	}
+	// A collateral this high overflows, which makes it insane, too.
+	perTB, overflow := settings.Collateral.Mul64WithOverflow(1e12)
+	if !overflow {
+		perTB, overflow = perTB.Mul64WithOverflow(30 * 144)
+	}
+	if overflow || perTB.Cmp(maxSaneCollateral) > 0 {
+		warnings = append(warnings, "collateral is impossibly high")
+	}
+	if settings.MaxCollateral.Cmp(settings.Collateral) < 0 {
+		warnings = append(warnings, "maximum collateral is lower than collateral")
+	}
+	if port, err := strconv.Atoi(settings.SiaMuxPort); err != nil || port < 1 || port > 65535 {
+		warnings = append(warnings, "invalid SiaMux port")
+	}
+	return warnings
+}
+
+// A SettingsChange is a change of a single host setting, detected by
+// the scan run at the given time.
+type SettingsChange struct {
+	At    time.Time `json:"at"`
+	Field string    `json:"field"`
+	Old   string    `json:"old"`
+	New   string    `json:"new"`
+}
+
+// trackedSettings are the host settings whose changes are logged.
+// RemainingStorage is left out, because it changes all the time.
+var trackedSettings = []struct {
+	name  string
+	value func(rhpv2.HostSettings) string
+}{
+	{"acceptingContracts", func(s rhpv2.HostSettings) string { return strconv.FormatBool(s.AcceptingContracts) }},
+	{"netAddress", func(s rhpv2.HostSettings) string { return s.NetAddress }},
+	{"siaMuxPort", func(s rhpv2.HostSettings) string { return s.SiaMuxPort }},
+	{"release", func(s rhpv2.HostSettings) string { return s.Release }},
+	{"maxDuration", func(s rhpv2.HostSettings) string { return strconv.FormatUint(s.MaxDuration, 10) }},
+	{"windowSize", func(s rhpv2.HostSettings) string { return strconv.FormatUint(s.WindowSize, 10) }},
+	{"totalStorage", func(s rhpv2.HostSettings) string { return strconv.FormatUint(s.TotalStorage, 10) }},
+	{"collateral", func(s rhpv2.HostSettings) string { return s.Collateral.String() }},
+	{"maxCollateral", func(s rhpv2.HostSettings) string { return s.MaxCollateral.String() }},
+	{"contractPrice", func(s rhpv2.HostSettings) string { return s.ContractPrice.String() }},
+	{"storagePrice", func(s rhpv2.HostSettings) string { return s.StoragePrice.String() }},
+	{"uploadBandwidthPrice", func(s rhpv2.HostSettings) string { return s.UploadBandwidthPrice.String() }},
+	{"downloadBandwidthPrice", func(s rhpv2.HostSettings) string { return s.DownloadBandwidthPrice.String() }},
+	{"baseRPCPrice", func(s rhpv2.HostSettings) string { return s.BaseRPCPrice.String() }},
+	{"sectorAccessPrice", func(s rhpv2.HostSettings) string { return s.SectorAccessPrice.String() }},
+}
+
+// SettingsChangeLog returns the changes of the host settings, oldest
+// first, found by comparing the settings of the consecutive successful
+// scans kept in the database.
+func (hdb *HostDB) SettingsChangeLog(pk types.PublicKey) []SettingsChange {
+	s, exists := hdb.hostStore(pk)
+	if !exists {
+		return nil
+	}
+	scans, err := s.getScans(pk, time.Unix(0, 0), time.Now())
+	if err != nil {
+		hdb.log.Error("couldn't get scans", zap.String("network", s.network), zap.Error(err))
+		return nil
+	}
+
+	var changes []SettingsChange
+	var prev *rhpv2.HostSettings
+	for i := range scans {
+		if !scans[i].Success || (scans[i].Settings == rhpv2.HostSettings{}) {
+			continue
+		}
+		current := &scans[i].Settings
+		if prev != nil {
+			for _, field := range trackedSettings {
+				if o, n := field.value(*prev), field.value(*current); o != n {
+					changes = append(changes, SettingsChange{
+						At:    scans[i].Timestamp,
+						Field: field.name,
+						Old:   o,
+						New:   n,
+					})
+				}
+			}
+		}
+		prev = current
+	}
+	return changes
+}
+
+// A PriceMover is a host whose storage price has changed by StorageDelta
+// SC/TB/month within a period of time.
+type PriceMover struct {
+	PublicKey    types.PublicKey `json:"publicKey"`
+	StorageDelta float64         `json:"storageDelta"`
+}
+
+// BiggestMovers returns up to n online Mainnet hosts whose storage price
+// changed the most, up or down, since the given time, the biggest change
+// first. The current price of each host is compared with the price found
+// by its first successful scan since then. The hosts whose price didn't
+// change are not included.
+func (hdb *HostDB) BiggestMovers(since time.Time, n int) []PriceMover {
+	if n <= 0 {
+		return nil
+	}
+	var movers []PriceMover
+	for _, host := range hdb.s.onlineHosts() {
+		scans, err := hdb.s.getScans(host.PublicKey, since, time.Now())
+		if err != nil {
+			hdb.log.Error("couldn't get scans", zap.String("network", hdb.s.network), zap.Error(err))
+			return nil
+		}
+		for _, scan := range scans {
+			if !scan.Success || (scan.Settings == rhpv2.HostSettings{}) {
+				continue
+			}
+			delta := (host.Settings.StoragePrice.Siacoins() - scan.Settings.StoragePrice.Siacoins()) * 1e12 * 30 * 144
+			if delta != 0 {
+				movers = append(movers, PriceMover{
+					PublicKey:    host.PublicKey,
+					StorageDelta: delta,
+				})
+			}
+			break
+		}
+	}
+	sort.SliceStable(movers, func(i, j int) bool {
+		return math.Abs(movers[i].StorageDelta) > math.Abs(movers[j].StorageDelta)
+	})
+	if len(movers) > n {
+		movers = movers[:n]
+	}
+	return movers
+}
@@ -0,0 +1,41 @@
+package hostdb
+
+import (
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+)
+
+// scanHash returns the hash of the scan that is signed. Only the values
+// that are stored in the database are included, with the same precision,
+// so that a scan read back from the database can be verified. The host's
+// public key and network are included, so that a signed scan cannot be
+// passed off as a scan of another host.
+func scanHash(pk types.PublicKey, network string, scan HostScan) types.Hash256 {
+	h := types.NewHasher()
+	h.WriteDistinguisher("hostscore/scan")
+	pk.EncodeTo(h.E)
+	h.E.WriteString(network)
+	h.E.WriteUint64(uint64(scan.Timestamp.Unix()))
+	h.E.WriteBool(scan.Success)
+	h.E.WriteUint64(uint64(scan.Latency.Milliseconds()))
+	h.E.WriteString(scan.Error)
+	utils.EncodeSettings(&scan.Settings, h.E)
+	utils.EncodePriceTable(&scan.PriceTable, h.E)
+	h.E.WriteUint64(uint64(scan.RHP3TTFB.Milliseconds()))
+	h.E.WriteUint64(uint64(scan.PriceTableFetch.Milliseconds()))
+	h.E.WriteString(scan.SiaMuxPort)
+	h.E.WriteBool(scan.EverOnline)
+	return h.Sum()
+}
+
+// signScan signs the host's scan with the key of the scanner.
+func (hdb *HostDB) signScan(host *HostDBEntry, scan *HostScan) {
+	scan.Signature = hdb.w.Key(host.Network).SignHash(scanHash(host.PublicKey, host.Network, *scan))
+}
+
+// VerifyScan returns true if the scan of the host with the given public
+// key on the given network was signed by the scanner with the given
+// public key.
+func VerifyScan(pk types.PublicKey, network string, scan HostScan, pubkey types.PublicKey) bool {
+	return pubkey.VerifyHash(scanHash(pk, network, scan), scan.Signature)
+}
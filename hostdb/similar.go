@@ -0,0 +1,138 @@
+package hostdb
+
+import (
+	"math"
+	"sort"
+
+	"go.sia.tech/core/types"
+)
+
+// hostFeatures is the feature vector of a host used to find similar hosts.
+// Unknown values are NaN.
+type hostFeatures []float64
+
+// featuresOf extracts the price and performance features of the host.
+func featuresOf(host HostDBEntry) hostFeatures {
+	var latency float64
+	var n int
+	for _, scan := range host.ScanHistory {
+		if scan.Success {
+			latency += float64(scan.Latency)
+			n++
+		}
+	}
+	if n > 0 {
+		latency /= float64(n)
+	} else {
+		latency = math.NaN()
+	}
+	upload, download := math.NaN(), math.NaN()
+	if host.LastBenchmark.Success {
+		upload = host.LastBenchmark.UploadSpeed
+		download = host.LastBenchmark.DownloadSpeed
+	}
+	return hostFeatures{
+		host.Settings.StoragePrice.Siacoins(),
+		host.Settings.UploadBandwidthPrice.Siacoins(),
+		host.Settings.DownloadBandwidthPrice.Siacoins(),
+		latency,
+		upload,
+		download,
+	}
+}
+
+// normalizeFeatures replaces each known feature value with its rank among
+// the values of the same feature, scaled to [0, 1]. This keeps the prices,
+// which span several orders of magnitude, from dominating the distance.
+func normalizeFeatures(features []hostFeatures) {
+	if len(features) == 0 {
+		return
+	}
+	for j := range features[0] {
+		var sorted []float64
+		for _, f := range features {
+			if !math.IsNaN(f[j]) {
+				sorted = append(sorted, f[j])
+			}
+		}
+		sort.Float64s(sorted)
+		for _, f := range features {
+			if math.IsNaN(f[j]) {
+				continue
+			}
+			if len(sorted) < 2 {
+				f[j] = 0
+				continue
+			}
+			f[j] = float64(sort.SearchFloat64s(sorted, f[j])) / float64(len(sorted)-1)
+		}
+	}
+}
+
+// featureDistance returns the distance between two normalized feature
+// vectors. Only the features known for both hosts are compared.
+func featureDistance(a, b hostFeatures) float64 {
+	var sum float64
+	var n int
+	for j := range a {
+		if math.IsNaN(a[j]) || math.IsNaN(b[j]) {
+			continue
+		}
+		d := a[j] - b[j]
+		sum += d * d
+		n++
+	}
+	if n == 0 {
+		return math.Inf(1)
+	}
+	return math.Sqrt(sum / float64(n))
+}
+
+// SimilarHosts returns up to n online hosts that are the most similar to
+// the given host in terms of prices and performance, closest
+// first. The host itself is not included.
+func (hdb *HostDB) SimilarHosts(pk types.PublicKey, n int) []HostDBEntry {
+	s, exists := hdb.hostStore(pk)
+	if !exists || n <= 0 {
+		return nil
+	}
+	host, exists := s.hostEntry(pk)
+	if !exists {
+		return nil
+	}
+
+	var candidates []HostDBEntry
+	for _, h := range s.onlineHosts() {
+		if h.PublicKey != pk {
+			candidates = append(candidates, h)
+		}
+	}
+	features := make([]hostFeatures, len(candidates)+1)
+	for i, h := range candidates {
+		features[i] = featuresOf(h)
+	}
+	features[len(candidates)] = featuresOf(host)
+	normalizeFeatures(features)
+
+	ref := features[len(candidates)]
+	distances := make([]float64, len(candidates))
+	for i := range candidates {
+		distances[i] = featureDistance(ref, features[i])
+	}
+	indices := make([]int, len(candidates))
+	for i := range indices {
+		indices[i] = i
+	}
+	sort.SliceStable(indices, func(i, j int) bool {
+		return distances[indices[i]] < distances[indices[j]]
+	})
+
+	if n > len(indices) {
+		n = len(indices)
+	}
+	similar := make([]HostDBEntry, 0, n)
+	for _, i := range indices[:n] {
+		similar = append(similar, candidates[i])
+	}
+	return similar
+}
@@ -0,0 +1,860 @@
+package hostdb
+
+import (
+	"cmp"
+	"errors"
+	"math"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+// isOnline returns true if the last scan of the host was successful.
+func isOnline(host *HostDBEntry) bool {
+	return len(host.ScanHistory) > 0 && host.ScanHistory[len(host.ScanHistory)-1].Success
+}
+
+// onlineWithin returns true if the last scan of the host was successful
+// and was run not longer than maxAge ago. A zero maxAge means no limit.
+func onlineWithin(host *HostDBEntry, maxAge time.Duration) bool {
+	if !isOnline(host) {
+		return false
+	}
+	lastScan := host.ScanHistory[len(host.ScanHistory)-1]
+	return maxAge <= 0 || time.Since(lastScan.Timestamp) <= maxAge
+}
+
+// IsOnline returns true if the last scan of the host was successful and
+// was run not longer than maxAge ago. A zero maxAge means no limit.
+// ErrNotSynced is returned if the scans of the host's network are paused,
+// because the node is not synced.
+func (hdb *HostDB) IsOnline(pk types.PublicKey, maxAge time.Duration) (bool, error) {
+	s, exists := hdb.hostStore(pk)
+	if !exists {
+		return false, ErrHostNotFound
+	}
+	host, exists := s.hostEntry(pk)
+	if !exists {
+		return false, ErrHostNotFound
+	}
+	if !hdb.scannable(host.Network) {
+		return false, ErrNotSynced
+	}
+	return onlineWithin(&host, maxAge), nil
+}
+
+// OnlineHosts returns the number of the hosts of the network that are not
+// blocked and whose last scan was successful and was run not longer than
+// maxAge ago, together with the total number of the hosts. A zero maxAge
+// means no limit.
+func (hdb *HostDB) OnlineHosts(network string, maxAge time.Duration) (online, total int) {
+	for _, s := range hdb.stores(network) {
+		s.mu.Lock()
+		for _, host := range s.hosts {
+			total++
+			if !host.Blocked && onlineWithin(host, maxAge) {
+				online++
+			}
+		}
+		s.mu.Unlock()
+	}
+	return
+}
+
+// An OnlineInterval is a period during which all scans of the host had
+// the same outcome.
+type OnlineInterval struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Online bool      `json:"online"`
+}
+
+// Timeline reconstructs the contiguous online and offline intervals of the
+// host from its scans within the given time range. Each interval ends where
+// the next one starts; the last one ends with the last scan.
+func (hdb *HostDB) Timeline(pk types.PublicKey, from, to time.Time) []OnlineInterval {
+	s, exists := hdb.hostStore(pk)
+	if !exists {
+		return nil
+	}
+	scans, err := s.getScans(pk, from, to)
+	if err != nil {
+		hdb.log.Error("couldn't get scans", zap.String("network", s.network), zap.Error(err))
+		return nil
+	}
+
+	var intervals []OnlineInterval
+	for _, scan := range scans {
+		if len(intervals) > 0 {
+			last := &intervals[len(intervals)-1]
+			last.End = scan.Timestamp
+			if last.Online == scan.Success {
+				continue
+			}
+		}
+		intervals = append(intervals, OnlineInterval{
+			Start:  scan.Timestamp,
+			End:    scan.Timestamp,
+			Online: scan.Success,
+		})
+	}
+	return intervals
+}
+
+// RecomputeUptime recalculates the uptime and the downtime of the host
+// from its scan history, repairing any drift of the incrementally
+// maintained values. ErrIncompleteHistory is returned if some of the
+// host's scans have already been pruned or compacted, because the
+// values would be rebuilt from a part of the history only.
+func (hdb *HostDB) RecomputeUptime(pk types.PublicKey) error {
+	s, exists := hdb.hostStore(pk)
+	if !exists {
+		return ErrHostNotFound
+	}
+	return s.recomputeUptime(pk)
+}
+
+// ReconcileInteractions recounts the recent interactions of the host from
+// its scan and benchmark history and corrects the stored counters. The
+// failures that happened while the node was offline were not counted
+// originally, but they are after the reconciliation.
+func (hdb *HostDB) ReconcileInteractions(pk types.PublicKey) error {
+	s, exists := hdb.hostStore(pk)
+	if !exists {
+		return ErrHostNotFound
+	}
+	return s.reconcileInteractions(pk)
+}
+
+// AvailabilityByHour returns the share of the successful scans of the host
+// for each hour of the day in UTC, based on the stored scan history. This
+// reveals the hosts that are only online at certain times. The hours with
+// no scans are set to -1.
+func (hdb *HostDB) AvailabilityByHour(pk types.PublicKey) [24]float64 {
+	var availability [24]float64
+	for i := range availability {
+		availability[i] = -1
+	}
+	s, exists := hdb.hostStore(pk)
+	if !exists {
+		return availability
+	}
+	scans, err := s.getScans(pk, time.Time{}, time.Now())
+	if err != nil {
+		hdb.log.Error("couldn't get scans", zap.Stringer("host", pk), zap.Error(err))
+		return availability
+	}
+
+	var successes, total [24]int
+	for _, scan := range scans {
+		hour := scan.Timestamp.UTC().Hour()
+		total[hour]++
+		if scan.Success {
+			successes[hour]++
+		}
+	}
+	for i := range availability {
+		if total[i] > 0 {
+			availability[i] = float64(successes[i]) / float64(total[i])
+		}
+	}
+	return availability
+}
+
+// FailingHosts returns the number of the hosts of both networks whose last
+// scan failed, split into those that were never online and those that
+// went down. The former usually have a bad announcement, while the
+// latter are temporarily unavailable.
+func (hdb *HostDB) FailingHosts() (neverOnline, wentDown int) {
+	for _, s := range hdb.stores("") {
+		s.mu.Lock()
+		for _, host := range s.hosts {
+			if host.Blocked || len(host.ScanHistory) == 0 {
+				continue
+			}
+			scan := host.ScanHistory[len(host.ScanHistory)-1]
+			if scan.Success {
+				continue
+			}
+			if scan.EverOnline || !host.LastSeen.IsZero() {
+				wentDown++
+			} else {
+				neverOnline++
+			}
+		}
+		s.mu.Unlock()
+	}
+	return
+}
+
+// ScanCoverage returns the fraction of the hosts of both networks that
+// had a scan attempt within the window. The hosts that are not scanned,
+// i.e. the blocked ones and those in ignored subnets, are not counted.
+// A low coverage means that the scanner can't keep up with the number
+// of the hosts.
+func (hdb *HostDB) ScanCoverage(within time.Duration) float64 {
+	var total, scanned int
+	for _, s := range hdb.stores("") {
+		s.mu.Lock()
+		for _, host := range s.hosts {
+			if host.Blocked || hdb.ignoredSubnets.isIgnored(host.IPNets) {
+				continue
+			}
+			total++
+			if len(host.ScanHistory) > 0 && time.Since(host.ScanHistory[len(host.ScanHistory)-1].Timestamp) <= within {
+				scanned++
+			}
+		}
+		s.mu.Unlock()
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(scanned) / float64(total)
+}
+
+// InteractionDebug returns the raw interaction state of the host together
+// with the estimated time of the last historic update, which is stored
+// as a block height. This helps to verify that the interactions decay
+// as expected.
+func (hdb *HostDB) InteractionDebug(pk types.PublicKey) (HostInteractions, time.Time, error) {
+	s, exists := hdb.hostStore(pk)
+	if !exists {
+		return HostInteractions{}, time.Time{}, ErrHostNotFound
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	host, exists := s.hosts[pk]
+	if !exists {
+		return HostInteractions{}, time.Time{}, ErrHostNotFound
+	}
+	if host.Interactions.LastUpdate == 0 {
+		return host.Interactions, time.Time{}, nil
+	}
+	return host.Interactions, s.heightTime(host.Interactions.LastUpdate), nil
+}
+
+// RecomputeAllUptime recalculates the uptime and the downtime of all
+// hosts of both networks. The hosts whose scan history is incomplete are
+// left untouched, and their number is returned.
+func (hdb *HostDB) RecomputeAllUptime() (skipped int, err error) {
+	for _, s := range hdb.stores("") {
+		s.mu.Lock()
+		pks := make([]types.PublicKey, 0, len(s.hosts))
+		for pk := range s.hosts {
+			pks = append(pks, pk)
+		}
+		s.mu.Unlock()
+		for _, pk := range pks {
+			err := s.recomputeUptime(pk)
+			if errors.Is(err, ErrIncompleteHistory) {
+				skipped++
+				continue
+			}
+			if err != nil {
+				return skipped, utils.AddContext(err, "couldn't recompute uptime")
+			}
+		}
+	}
+	return skipped, nil
+}
+
+// onlineHosts returns the copies of the hosts that are not blocked
+// and were online during their last scan.
+func (s *hostDBStore) onlineHosts() []HostDBEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var hosts []HostDBEntry
+	for _, host := range s.hosts {
+		if host.Blocked || !isOnline(host) {
+			continue
+		}
+		hosts = append(hosts, *host)
+	}
+	return hosts
+}
+
+// StorageGini returns the Gini coefficient of the total storage advertised
+// by the online Mainnet hosts. A value of 0 means that the capacity is
+// distributed evenly, while values close to 1 mean that it is concentrated
+// among a few large hosts.
+func (hdb *HostDB) StorageGini() float64 {
+	var capacities []float64
+	for _, host := range hdb.s.onlineHosts() {
+		capacities = append(capacities, float64(host.Settings.TotalStorage))
+	}
+	return gini(capacities)
+}
+
+// VersionDistribution returns the number of online Mainnet hosts per
+// announced software version. Hosts not reporting a version are counted
+// under "unknown".
+func (hdb *HostDB) VersionDistribution() map[string]int {
+	versions := make(map[string]int)
+	for _, host := range hdb.s.onlineHosts() {
+		version := host.Settings.Release
+		if version == "" {
+			version = "unknown"
+		}
+		versions[version]++
+	}
+	return versions
+}
+
+// estimatedScanSize is the approximate amount of data exchanged during
+// a single scan: the handshakes, the host settings, and the price table.
+const estimatedScanSize = 1 << 13 // 8 KiB
+
+// EstimatedScanBandwidth estimates the average traffic in bytes per second
+// the scanner generates with the current hosts of both networks, scan
+// intervals, and benchmark intervals. A benchmark uploads and downloads
+// benchmarkBatchSize bytes.
+func (hdb *HostDB) EstimatedScanBandwidth() int64 {
+	var bandwidth float64
+	for _, s := range hdb.stores("") {
+		s.mu.Lock()
+		for _, host := range s.hosts {
+			if host.Blocked || hdb.ignoredSubnets.isIgnored(host.IPNets) {
+				continue
+			}
+			if interval := s.calculateScanInterval(host); interval != math.MaxInt64 {
+				bandwidth += estimatedScanSize / interval.Seconds()
+			}
+			if !isOnline(host) {
+				continue
+			}
+			if interval := s.calculateBenchmarkInterval(host); interval != math.MaxInt64 {
+				bandwidth += 2 * benchmarkBatchSize / interval.Seconds()
+			}
+		}
+		s.mu.Unlock()
+	}
+	return int64(bandwidth)
+}
+
+// SubnetStorage is the total storage advertised by the hosts of a subnet.
+type SubnetStorage struct {
+	Subnet       string `json:"subnet"`
+	TotalStorage uint64 `json:"totalStorage"`
+}
+
+// StorageBySubnet returns up to topN subnets with the largest total
+// storage advertised by the online Mainnet hosts, largest first. A host
+// with several subnets contributes to each of them.
+func (hdb *HostDB) StorageBySubnet(topN int) []SubnetStorage {
+	storage := make(map[string]uint64)
+	for _, host := range hdb.s.onlineHosts() {
+		for _, subnet := range host.IPNets {
+			storage[subnet] += host.Settings.TotalStorage
+		}
+	}
+
+	result := make([]SubnetStorage, 0, len(storage))
+	for subnet, total := range storage {
+		result = append(result, SubnetStorage{Subnet: subnet, TotalStorage: total})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].TotalStorage == result[j].TotalStorage {
+			return result[i].Subnet < result[j].Subnet
+		}
+		return result[i].TotalStorage > result[j].TotalStorage
+	})
+	if topN >= 0 && len(result) > topN {
+		result = result[:topN]
+	}
+	return result
+}
+
+// BenchmarkErrorBreakdown returns the number of failed benchmarks
+// since the given time per error category.
+func (hdb *HostDB) BenchmarkErrorBreakdown(since time.Time) (map[string]int, error) {
+	breakdown := make(map[string]int)
+	for _, s := range hdb.stores("") {
+		b, err := s.getBenchmarkErrors(since)
+		if err != nil {
+			return nil, err
+		}
+		for category, count := range b {
+			breakdown[category] += count
+		}
+	}
+	return breakdown, nil
+}
+
+const (
+	// failingBenchmarksWindow is how far back HostsFailingBenchmarks looks.
+	// It matches the retention period of the scans.
+	failingBenchmarksWindow = 7 * 24 * time.Hour
+	minSuccessfulScans      = 10
+	minFailedBenchmarks     = 3
+)
+
+// HostsFailingBenchmarks returns the hosts that have been scanned
+// successfully many times during the last week but failed every
+// benchmark within the same period.
+func (hdb *HostDB) HostsFailingBenchmarks() []HostDBEntry {
+	var hosts []HostDBEntry
+	since := time.Now().Add(-failingBenchmarksWindow)
+	for _, s := range hdb.stores("") {
+		pks, err := s.getHostsFailingBenchmarks(since, minSuccessfulScans, minFailedBenchmarks)
+		if err != nil {
+			hdb.log.Error("couldn't get hosts failing benchmarks", zap.String("network", s.network), zap.Error(err))
+			continue
+		}
+		for _, pk := range pks {
+			if host, exists := s.hostEntry(pk); exists && !host.Blocked {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	return hosts
+}
+
+// RecentlyRecoveredHosts returns the hosts of both networks whose last
+// scan was successful, but which failed a scan since the given time.
+func (hdb *HostDB) RecentlyRecoveredHosts(since time.Time) []HostDBEntry {
+	var hosts []HostDBEntry
+	for _, s := range hdb.stores("") {
+		pks, err := s.getHostsWithFailedScans(since)
+		if err != nil {
+			hdb.log.Error("couldn't get hosts with failed scans", zap.String("network", s.network), zap.Error(err))
+			continue
+		}
+		for _, pk := range pks {
+			if host, exists := s.hostEntry(pk); exists && !host.Blocked && isOnline(&host) {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	return hosts
+}
+
+// The standard renter workload: store 1 TB for 3 months and download
+// it once.
+const (
+	workloadSize     = 1e12
+	workloadDuration = 3 * 30 * 144 // blocks
+)
+
+// StandardWorkloadCost returns the cost of the standard renter workload
+// on the host, calculated from its advertised settings. If the cost
+// overflows, types.MaxCurrency is returned.
+func (h HostDBEntry) StandardWorkloadCost() types.Currency {
+	storage, o1 := h.Settings.StoragePrice.Mul64WithOverflow(workloadSize)
+	storage, o2 := storage.Mul64WithOverflow(workloadDuration)
+	upload, o3 := h.Settings.UploadBandwidthPrice.Mul64WithOverflow(workloadSize)
+	download, o4 := h.Settings.DownloadBandwidthPrice.Mul64WithOverflow(workloadSize)
+	cost, o5 := h.Settings.ContractPrice.AddWithOverflow(storage)
+	cost, o6 := cost.AddWithOverflow(upload)
+	cost, o7 := cost.AddWithOverflow(download)
+	if o1 || o2 || o3 || o4 || o5 || o6 || o7 {
+		return types.MaxCurrency
+	}
+	return cost
+}
+
+// HostsWithAsymmetricReachability returns the hosts that pass at least 90%
+// of the scans but fail more than half of the benchmarks, mostly while
+// transferring the data. Unlike HostsFailingBenchmarks, this points at
+// the network path of the host, which can handle small RPCs but not
+// large transfers, rather than at the host software.
+func (hdb *HostDB) HostsWithAsymmetricReachability() []HostDBEntry {
+	var hosts []HostDBEntry
+	since := time.Now().Add(-failingBenchmarksWindow)
+	for _, s := range hdb.stores("") {
+		pks, err := s.getAsymmetricHosts(since, minSuccessfulScans, minFailedBenchmarks, 0.9, 0.5)
+		if err != nil {
+			hdb.log.Error("couldn't get hosts with asymmetric reachability", zap.String("network", s.network), zap.Error(err))
+			continue
+		}
+		for _, pk := range pks {
+			if host, exists := s.hostEntry(pk); exists && !host.Blocked {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	return hosts
+}
+
+// minReliableScans is the number of successful scans a host needs to
+// be considered reliably online.
+const minReliableScans = 10
+
+// ChurnRate returns the number of hosts of both networks that were lost
+// and gained within the given time range. A host is lost if it had been
+// reliably online but has not been seen online since some point within
+// the range. A host is gained if it was discovered and came online within
+// the range. Since old scans are pruned, the range should lie within the
+// last week.
+func (hdb *HostDB) ChurnRate(from, to time.Time) (lost, gained int) {
+	for _, s := range hdb.stores("") {
+		l, g, err := s.getChurn(from, to, minReliableScans)
+		if err != nil {
+			hdb.log.Error("couldn't calculate churn", zap.String("network", s.network), zap.Error(err))
+			continue
+		}
+		lost += l
+		gained += g
+	}
+	return
+}
+
+// MedianPrices contains the median prices of the online Mainnet hosts,
+// as advertised in their settings.
+type MedianPrices struct {
+	StoragePrice  types.Currency `json:"storagePrice"`
+	UploadPrice   types.Currency `json:"uploadPrice"`
+	DownloadPrice types.Currency `json:"downloadPrice"`
+	ContractPrice types.Currency `json:"contractPrice"`
+	UpdatedAt     time.Time      `json:"updatedAt"`
+}
+
+// MedianPrices returns the cached network median prices. The cache is
+// refreshed by the scan loop every 10 minutes.
+func (hdb *HostDB) MedianPrices() MedianPrices {
+	hdb.mu.Lock()
+	defer hdb.mu.Unlock()
+	return hdb.medianPrices
+}
+
+// updateMedianPrices recalculates the network median prices.
+func (hdb *HostDB) updateMedianPrices() {
+	var storage, upload, download, contract []types.Currency
+	for _, host := range hdb.s.onlineHosts() {
+		storage = append(storage, host.Settings.StoragePrice)
+		upload = append(upload, host.Settings.UploadBandwidthPrice)
+		download = append(download, host.Settings.DownloadBandwidthPrice)
+		contract = append(contract, host.Settings.ContractPrice)
+	}
+	mp := MedianPrices{
+		StoragePrice:  medianCurrency(storage),
+		UploadPrice:   medianCurrency(upload),
+		DownloadPrice: medianCurrency(download),
+		ContractPrice: medianCurrency(contract),
+		UpdatedAt:     time.Now(),
+	}
+	sample := PriceIndexSample{
+		Timestamp: mp.UpdatedAt,
+		Index:     hdb.PriceIndex(),
+	}
+	hdb.mu.Lock()
+	hdb.medianPrices = mp
+	hdb.priceIndexHistory = append(hdb.priceIndexHistory, sample)
+	if len(hdb.priceIndexHistory) > maxPriceIndexSamples {
+		hdb.priceIndexHistory = hdb.priceIndexHistory[1:]
+	}
+	// medianCurrency has sorted the slices.
+	hdb.priceDist = priceDistribution{
+		storage:  storage,
+		upload:   upload,
+		download: download,
+	}
+	hdb.mu.Unlock()
+}
+
+// maxPriceIndexSamples is the number of the price index samples kept in
+// memory. With the samples taken every medianPricesInterval, this covers
+// 30 days.
+const maxPriceIndexSamples = 30 * 24 * int(time.Hour/medianPricesInterval)
+
+// PriceIndexSample is the value of the price index at a certain time.
+type PriceIndexSample struct {
+	Timestamp time.Time `json:"timestamp"`
+	Index     float64   `json:"index"`
+}
+
+// PriceIndex returns the average storage price of the online Mainnet hosts
+// in SC/TB/month, weighted by their total storage. This way, the prices of
+// the big hosts count more than those of the small ones.
+func (hdb *HostDB) PriceIndex() float64 {
+	var weighted, total float64
+	for _, host := range hdb.s.onlineHosts() {
+		capacity := float64(host.Settings.TotalStorage)
+		weighted += host.Settings.StoragePrice.Siacoins() * 1e12 * 30 * 144 * capacity
+		total += capacity
+	}
+	if total == 0 {
+		return 0
+	}
+	return weighted / total
+}
+
+// PriceIndexHistory returns the price index samples of the last 30 days,
+// oldest first. A sample is taken every time the median prices are
+// updated.
+func (hdb *HostDB) PriceIndexHistory() []PriceIndexSample {
+	hdb.mu.Lock()
+	defer hdb.mu.Unlock()
+	history := make([]PriceIndexSample, len(hdb.priceIndexHistory))
+	copy(history, hdb.priceIndexHistory)
+	return history
+}
+
+// priceDistribution contains the sorted prices of the online Mainnet
+// hosts.
+type priceDistribution struct {
+	storage  []types.Currency
+	upload   []types.Currency
+	download []types.Currency
+}
+
+// PricePercentile returns the position of the host's storage, upload,
+// and download prices in the price distribution of the online Mainnet
+// hosts, from 0 (cheapest) to 100 (most expensive). The distribution is
+// refreshed together with the median prices.
+func (hdb *HostDB) PricePercentile(pk types.PublicKey) (storage, upload, download float64, err error) {
+	host, exists := hdb.s.hostEntry(pk)
+	if !exists {
+		return 0, 0, 0, ErrHostNotFound
+	}
+	hdb.mu.Lock()
+	dist := hdb.priceDist
+	hdb.mu.Unlock()
+	if len(dist.storage) == 0 {
+		return 0, 0, 0, errors.New("price distribution not available yet")
+	}
+	storage = percentileOf(dist.storage, host.Settings.StoragePrice, types.Currency.Cmp)
+	upload = percentileOf(dist.upload, host.Settings.UploadBandwidthPrice, types.Currency.Cmp)
+	download = percentileOf(dist.download, host.Settings.DownloadBandwidthPrice, types.Currency.Cmp)
+	return
+}
+
+// percentileOf returns the percentile rank of the value among the sorted
+// values, based on how many of the values are lower.
+func percentileOf[T any](sorted []T, value T, cmp func(a, b T) int) float64 {
+	if len(sorted) < 2 {
+		return 0
+	}
+	i, _ := slices.BinarySearchFunc(sorted, value, cmp)
+	if i > len(sorted)-1 {
+		i = len(sorted) - 1
+	}
+	return 100 * float64(i) / float64(len(sorted)-1)
+}
+
+// medianCurrency returns the median of the provided values.
+func medianCurrency(values []types.Currency) types.Currency {
+	n := len(values)
+	if n == 0 {
+		return types.ZeroCurrency
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].Cmp(values[j]) < 0 })
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return values[n/2-1].Add(values[n/2]).Div64(2)
+}
+
+// priceTableExpiry returns the time when the host's stored price table
+// expires, based on the last scan or benchmark that fetched it. A zero
+// time is returned if the price table wasn't fetched by any of the scans
+// kept in memory.
+func priceTableExpiry(host HostDBEntry) time.Time {
+	if !host.BenchmarkRefresh.IsZero() && host.PriceTable.Validity > 0 && host.BenchmarkRefresh.Equal(lastRefresh(&host)) {
+		return host.BenchmarkRefresh.Add(host.PriceTable.Validity)
+	}
+	for i := len(host.ScanHistory) - 1; i >= 0; i-- {
+		scan := host.ScanHistory[i]
+		if scan.PriceTable.Validity > 0 {
+			return scan.Timestamp.Add(scan.PriceTable.Validity)
+		}
+	}
+	return time.Time{}
+}
+
+// ExpiredPriceTableHosts returns the online hosts of both networks whose
+// stored price table has expired without being refreshed, the longest
+// expired first. Their current pricing is unverified.
+func (hdb *HostDB) ExpiredPriceTableHosts() []HostDBEntry {
+	var hosts []HostDBEntry
+	var expiries []time.Time
+	for _, s := range hdb.stores("") {
+		for _, host := range s.onlineHosts() {
+			expiry := priceTableExpiry(host)
+			if expiry.IsZero() || time.Now().Before(expiry) {
+				continue
+			}
+			hosts = append(hosts, host)
+			expiries = append(expiries, expiry)
+		}
+	}
+	sort.Sort(byExpiry{hosts, expiries})
+	return hosts
+}
+
+// byExpiry sorts the hosts by the expiry of their price tables.
+type byExpiry struct {
+	hosts    []HostDBEntry
+	expiries []time.Time
+}
+
+func (b byExpiry) Len() int           { return len(b.hosts) }
+func (b byExpiry) Less(i, j int) bool { return b.expiries[i].Before(b.expiries[j]) }
+func (b byExpiry) Swap(i, j int) {
+	b.hosts[i], b.hosts[j] = b.hosts[j], b.hosts[i]
+	b.expiries[i], b.expiries[j] = b.expiries[j], b.expiries[i]
+}
+
+const (
+	// ipChangePenalty is the share of the stability score lost right
+	// after an IP change. The penalty decays with ipChangeDecay.
+	ipChangePenalty = 0.5
+	ipChangeDecay   = 30 * 24 * time.Hour
+
+	// keyChangePenalty is how much each previous key at the host's
+	// address reduces the stability score.
+	keyChangePenalty = 0.25
+)
+
+// StabilityScore returns the uptime of the host, from 0 to 1, reduced by
+// the address and the key changes. A recent IP change costs up to half of
+// the score, which recovers over time, and each previous key used at the
+// same address reduces the score further.
+func (h HostDBEntry) StabilityScore() float64 {
+	score := uptimeRatio(h)
+
+	// The IP change recorded when the host is first seen doesn't count.
+	if h.LastIPChange.After(h.FirstSeen) {
+		age := time.Since(h.LastIPChange)
+		score *= 1 - ipChangePenalty*math.Exp(-float64(age)/float64(ipChangeDecay))
+	}
+
+	return score / (1 + keyChangePenalty*float64(len(h.PreviousKeys)))
+}
+
+// batchTransferTime is the time in which a host is expected to transfer
+// a batch of the maximum size it advertises.
+const batchTransferTime = time.Minute
+
+// ThroughputHonesty compares the throughput measured by the last benchmark
+// with the throughput implied by the host's settings. The settings do not
+// advertise a speed directly, but a host that accepts batches of a certain
+// size should be able to transfer such a batch within batchTransferTime.
+// The lower of the upload and the download ratios is returned: a value
+// below 1 means that the host promises more than it delivers. If there
+// is no successful benchmark or the batch sizes are unknown, 0 is returned.
+func (h HostDBEntry) ThroughputHonesty() float64 {
+	b := h.LastBenchmark
+	if !b.Success || b.Partial {
+		return 0
+	}
+	if h.Settings.MaxReviseBatchSize == 0 || h.Settings.MaxDownloadBatchSize == 0 {
+		return 0
+	}
+	impliedUpload := float64(h.Settings.MaxReviseBatchSize) / batchTransferTime.Seconds()
+	impliedDownload := float64(h.Settings.MaxDownloadBatchSize) / batchTransferTime.Seconds()
+	return math.Min(b.UploadSpeed/impliedUpload, b.DownloadSpeed/impliedDownload)
+}
+
+// recentBenchmarkWindow is how old a benchmark can be to be included
+// in the TTFB statistics.
+const recentBenchmarkWindow = 24 * time.Hour
+
+// recentTTFBs returns the sorted TTFBs of the online Mainnet hosts with
+// a successful benchmark within recentBenchmarkWindow. The benchmarks
+// that were interrupted before the first byte was downloaded are skipped.
+func (hdb *HostDB) recentTTFBs() []time.Duration {
+	var ttfbs []time.Duration
+	for _, host := range hdb.s.onlineHosts() {
+		b := host.LastBenchmark
+		if b.Success && b.TTFB > 0 && time.Since(b.Timestamp) < recentBenchmarkWindow {
+			ttfbs = append(ttfbs, b.TTFB)
+		}
+	}
+	sort.Slice(ttfbs, func(i, j int) bool { return ttfbs[i] < ttfbs[j] })
+	return ttfbs
+}
+
+// MedianTTFB returns the median time to first byte of the online Mainnet
+// hosts benchmarked within the last 24 hours.
+func (hdb *HostDB) MedianTTFB() time.Duration {
+	ttfbs := hdb.recentTTFBs()
+	n := len(ttfbs)
+	if n == 0 {
+		return 0
+	}
+	if n%2 == 1 {
+		return ttfbs[n/2]
+	}
+	return (ttfbs[n/2-1] + ttfbs[n/2]) / 2
+}
+
+// TTFBPercentile returns the position of the host's time to first byte
+// among the online Mainnet hosts benchmarked within the last 24 hours,
+// from 0 (fastest) to 100 (slowest).
+func (hdb *HostDB) TTFBPercentile(pk types.PublicKey) (float64, error) {
+	host, exists := hdb.s.hostEntry(pk)
+	if !exists {
+		return 0, ErrHostNotFound
+	}
+	if !host.LastBenchmark.Success || host.LastBenchmark.TTFB == 0 {
+		return 0, errors.New("no successful benchmark")
+	}
+	return percentileOf(hdb.recentTTFBs(), host.LastBenchmark.TTFB, cmp.Compare[time.Duration]), nil
+}
+
+// PricePerformanceCorrelation returns the Pearson correlation coefficient
+// between the storage price and the average of the upload and download
+// speeds of the online Mainnet hosts with a successful last benchmark.
+// A positive value means that the more expensive hosts tend to perform
+// better.
+func (hdb *HostDB) PricePerformanceCorrelation() float64 {
+	var prices, speeds []float64
+	for _, host := range hdb.s.onlineHosts() {
+		if !host.LastBenchmark.Success {
+			continue
+		}
+		prices = append(prices, host.Settings.StoragePrice.Siacoins())
+		speeds = append(speeds, (host.LastBenchmark.UploadSpeed+host.LastBenchmark.DownloadSpeed)/2)
+	}
+	return correlation(prices, speeds)
+}
+
+// correlation calculates the Pearson correlation coefficient of the
+// provided samples.
+func correlation(x, y []float64) float64 {
+	n := len(x)
+	if n < 2 || n != len(y) {
+		return 0
+	}
+	var meanX, meanY float64
+	for i := range x {
+		meanX += x[i]
+		meanY += y[i]
+	}
+	meanX /= float64(n)
+	meanY /= float64(n)
+	var cov, varX, varY float64
+	for i := range x {
+		dx, dy := x[i]-meanX, y[i]-meanY
+		cov += dx * dy
+		varX += dx * dx
+		varY += dy * dy
+	}
+	if varX == 0 || varY == 0 {
+		return 0
+	}
+	return cov / math.Sqrt(varX*varY)
+}
+
+// gini calculates the Gini coefficient of the provided values.
+func gini(values []float64) float64 {
+	n := len(values)
+	if n == 0 {
+		return 0
+	}
+	sort.Float64s(values)
+	var sum, weighted float64
+	for i, v := range values {
+		sum += v
+		weighted += float64(i+1) * v
+	}
+	if sum == 0 {
+		return 0
+	}
+	return 2*weighted/(float64(n)*sum) - float64(n+1)/float64(n)
+}
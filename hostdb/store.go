@@ -3,7 +3,10 @@ package hostdb
 import (
 	"bytes"
 	"database/sql"
+	"encoding/hex"
 	"errors"
+	"net"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -27,6 +30,14 @@ type hostDBStore struct {
 	blockedHosts map[types.PublicKey]struct{}
 
 	activeHostsCache map[types.PublicKey][]string
+	ipChanges        map[types.PublicKey]time.Time
+
+	// addresses maps each net address to the last host updated with it.
+	addresses map[string]types.PublicKey
+
+	// maxID is the largest ID assigned to a host. The IDs of the removed
+	// hosts are not reused.
+	maxID int
 
 	mu sync.Mutex
 
@@ -34,6 +45,9 @@ type hostDBStore struct {
 	lastCommitted time.Time
 
 	lastUpdate HostUpdates
+
+	// needsBackfill is set if migrate added a denormalized column.
+	needsBackfill bool
 }
 
 func newHostDBStore(db *sql.DB, logger *zap.Logger, network string, domains *blockedDomains) (*hostDBStore, types.ChainIndex, error) {
@@ -44,6 +58,12 @@ func newHostDBStore(db *sql.DB, logger *zap.Logger, network string, domains *blo
 		hosts:            make(map[types.PublicKey]*HostDBEntry),
 		blockedHosts:     make(map[types.PublicKey]struct{}),
 		activeHostsCache: make(map[types.PublicKey][]string),
+		ipChanges:        make(map[types.PublicKey]time.Time),
+		addresses:        make(map[string]types.PublicKey),
+	}
+	if err := s.migrate(); err != nil {
+		s.log.Error("couldn't migrate database", zap.String("network", s.network), zap.Error(err))
+		return nil, types.ChainIndex{}, err
 	}
 	err := s.load(domains)
 	if err != nil {
@@ -60,15 +80,24 @@ func (s *hostDBStore) update(host *HostDBEntry) error {
 		panic("networks don't match")
 	}
 	if s.tx == nil {
-		return errors.New("there is no transaction")
+		return ErrStoreClosed
 	}
-	if host.Blocked || s.hdb.blockedDomains.isBlocked(host.NetAddress) {
+	if host.Blocked || s.hdb.blockedDomains.isBlocked(host.NetAddress) || s.hdb.blockedDomains.isKeyBlocked(host.PublicKey) {
 		host.Blocked = true
 		s.blockedHosts[host.PublicKey] = struct{}{}
 	} else {
 		delete(s.blockedHosts, host.PublicKey)
 	}
 	s.hosts[host.PublicKey] = host
+	s.addresses[host.NetAddress] = host.PublicKey
+	var benchmarkedAt int64
+	var ul, dl float64
+	if !host.LastBenchmark.Timestamp.IsZero() {
+		benchmarkedAt = host.LastBenchmark.Timestamp.Unix()
+	}
+	if host.LastBenchmark.Success {
+		ul, dl = host.LastBenchmark.UploadSpeed, host.LastBenchmark.DownloadSpeed
+	}
 	var rev, settings, pt bytes.Buffer
 	e := types.NewEncoder(&rev)
 	if (host.Revision.ParentID != types.FileContractID{}) {
@@ -106,10 +135,21 @@ func (s *hostDBStore) update(host *HostDBEntry) error {
 			revision,
 			settings,
 			price_table,
+			scan_interval,
+			priority,
+			favorite,
+			previous_keys,
+			accepting_contracts,
+			remaining_storage,
+			storage_price,
+			max_duration,
+			benchmarked_at,
+			upload_speed,
+			download_speed,
 			modified,
 			fetched
 		)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) AS new
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) AS new
 		ON DUPLICATE KEY UPDATE
 			first_seen = new.first_seen,
 			known_since = new.known_since,
@@ -128,6 +168,17 @@ func (s *hostDBStore) update(host *HostDBEntry) error {
 			revision = new.revision,
 			settings = new.settings,
 			price_table = new.price_table,
+			scan_interval = new.scan_interval,
+			priority = new.priority,
+			favorite = new.favorite,
+			previous_keys = new.previous_keys,
+			accepting_contracts = new.accepting_contracts,
+			remaining_storage = new.remaining_storage,
+			storage_price = new.storage_price,
+			max_duration = new.max_duration,
+			benchmarked_at = new.benchmarked_at,
+			upload_speed = new.upload_speed,
+			download_speed = new.download_speed,
 			modified = new.modified
 	`,
 		host.ID,
@@ -149,6 +200,17 @@ func (s *hostDBStore) update(host *HostDBEntry) error {
 		rev.Bytes(),
 		settings.Bytes(),
 		pt.Bytes(),
+		int64(host.ScanInterval.Seconds()),
+		host.Priority,
+		host.Favorite,
+		encodeKeys(host.PreviousKeys),
+		host.Settings.AcceptingContracts,
+		host.Settings.RemainingStorage,
+		host.Settings.StoragePrice.Siacoins()*1e12*30*144,
+		host.Settings.MaxDuration,
+		benchmarkedAt,
+		ul,
+		dl,
 		time.Now().Unix(),
 		0,
 	)
@@ -156,6 +218,28 @@ func (s *hostDBStore) update(host *HostDBEntry) error {
 		return err
 	}
 
+	// Record the address change if there was one.
+	if !host.LastIPChange.IsZero() && host.LastIPChange.After(s.ipChanges[host.PublicKey]) {
+		_, err := s.tx.Exec(`
+			INSERT INTO hdb_ip_changes_`+s.network+` (
+				public_key,
+				changed_at,
+				net_address,
+				ip_nets
+			)
+			VALUES (?, ?, ?, ?)
+		`,
+			host.PublicKey[:],
+			host.LastIPChange.Unix(),
+			host.NetAddress,
+			strings.Join(host.IPNets, ";"),
+		)
+		if err != nil {
+			return err
+		}
+		s.ipChanges[host.PublicKey] = host.LastIPChange
+	}
+
 	if err := s.tx.Commit(); err != nil {
 		return err
 	}
@@ -164,6 +248,248 @@ func (s *hostDBStore) update(host *HostDBEntry) error {
 	return err
 }
 
+// setScanInterval sets a custom scan interval of the host.
+func (s *hostDBStore) setScanInterval(pk types.PublicKey, d time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	host, exists := s.hosts[pk]
+	if !exists {
+		return ErrHostNotFound
+	}
+	host.ScanInterval = d
+	return s.update(host)
+}
+
+// blockHosts blocks the hosts with the given public keys and the hosts
+// from the given subnets in one transaction. It returns the number of
+// the newly blocked hosts.
+// blockHosts blocks the listed hosts and the hosts in the listed subnets
+// within the given transaction, and returns them. The host entries are
+// not changed, so that nothing needs to be undone if the transaction is
+// rolled back.
+// NOTE: a lock must be acquired before calling blockHosts.
+func (s *hostDBStore) blockHosts(tx *sql.Tx, pks map[types.PublicKey]struct{}, subnets []*net.IPNet) ([]*HostDBEntry, error) {
+	var blocked []*HostDBEntry
+	for pk, host := range s.hosts {
+		if host.Blocked {
+			continue
+		}
+		if _, listed := pks[pk]; !listed && !subnetsOverlap(host.IPNets, subnets) {
+			continue
+		}
+		_, err := tx.Exec(`
+			UPDATE hdb_hosts_`+s.network+`
+			SET blocked = TRUE, modified = ?
+			WHERE public_key = ?
+		`, time.Now().Unix(), pk[:])
+		if err != nil {
+			return nil, utils.AddContext(err, "couldn't block host")
+		}
+		blocked = append(blocked, host)
+	}
+	return blocked, nil
+}
+
+// setPriority sets the scan priority of the host.
+func (s *hostDBStore) setPriority(pk types.PublicKey, priority int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	host, exists := s.hosts[pk]
+	if !exists {
+		return ErrHostNotFound
+	}
+	host.Priority = priority
+	return s.update(host)
+}
+
+// recomputeUptime recalculates the uptime and the downtime of the host
+// from the stored scans and saves the corrected values.
+func (s *hostDBStore) recomputeUptime(pk types.PublicKey) error {
+	s.mu.Lock()
+	host, exists := s.hosts[pk]
+	if !exists {
+		s.mu.Unlock()
+		return ErrHostNotFound
+	}
+	firstSeen := host.FirstSeen
+	s.mu.Unlock()
+
+	complete, err := s.historyComplete(pk, firstSeen)
+	if err != nil {
+		return err
+	}
+	if !complete {
+		return ErrIncompleteHistory
+	}
+
+	scans, err := s.getScans(pk, time.Unix(0, 0), time.Now())
+	if err != nil {
+		return err
+	}
+
+	var uptime, downtime time.Duration
+	for i := 1; i < len(scans); i++ {
+		interval := scans[i].Timestamp.Sub(scans[i-1].Timestamp)
+		if scans[i].Success {
+			uptime += interval
+		} else {
+			downtime += interval
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	host, exists = s.hosts[pk]
+	if !exists {
+		return ErrHostNotFound
+	}
+	host.Uptime = uptime
+	host.Downtime = downtime
+	return s.update(host)
+}
+
+// historyComplete returns true if none of the host's scans can have been
+// pruned or compacted yet. This is the case if the host was first seen
+// within the shorter of the retention periods and there are no rollups
+// of its scans.
+func (s *hostDBStore) historyComplete(pk types.PublicKey, firstSeen time.Time) (bool, error) {
+	retention := s.hdb.successfulScanRetention
+	if s.hdb.failedScanRetention < retention {
+		retention = s.hdb.failedScanRetention
+	}
+	if firstSeen.Before(time.Now().AddDate(0, 0, -retention)) {
+		return false, nil
+	}
+
+	var count int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*)
+		FROM hdb_rollups_`+s.network+`
+		WHERE public_key = ?
+	`, pk[:]).Scan(&count)
+	if err != nil {
+		return false, utils.AddContext(err, "couldn't count rollups")
+	}
+	return count == 0, nil
+}
+
+// previousKeys returns the key of the host last seen at the address,
+// if it differs from the given one. This happens when the host operator
+// resets their host.
+// NOTE: a lock must be acquired before calling previousKeys.
+func (s *hostDBStore) previousKeys(addr string, pk types.PublicKey) []types.PublicKey {
+	prev, exists := s.addresses[addr]
+	if !exists || prev == pk {
+		return nil
+	}
+	return []types.PublicKey{prev}
+}
+
+// encodeKeys encodes the public keys for storing in the database.
+func encodeKeys(keys []types.PublicKey) string {
+	encoded := make([]string, len(keys))
+	for i, key := range keys {
+		encoded[i] = hex.EncodeToString(key[:])
+	}
+	return strings.Join(encoded, ";")
+}
+
+// decodeKeys decodes the public keys stored in the database.
+func decodeKeys(s string) []types.PublicKey {
+	var keys []types.PublicKey
+	for _, str := range strings.Split(s, ";") {
+		b, err := hex.DecodeString(str)
+		if err != nil || len(b) != len(types.PublicKey{}) {
+			continue
+		}
+		keys = append(keys, types.PublicKey(b))
+	}
+	return keys
+}
+
+// setFavorite adds the host to or removes it from the favorites.
+func (s *hostDBStore) setFavorite(pk types.PublicKey, fav bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	host, exists := s.hosts[pk]
+	if !exists {
+		return ErrHostNotFound
+	}
+	host.Favorite = fav
+	return s.update(host)
+}
+
+// heightTime estimates the time when the block at the given height was
+// mined, assuming ten minutes per block since then.
+// NOTE: a lock must be acquired before calling heightTime.
+func (s *hostDBStore) heightTime(height uint64) time.Time {
+	var blocks uint64
+	if s.tip.Height > height {
+		blocks = s.tip.Height - height
+	}
+	return time.Now().Add(-time.Duration(blocks) * 10 * time.Minute)
+}
+
+// reconcileInteractions recounts the recent interactions of the host
+// from the scans and benchmarks run since the last historic update, and
+// saves the corrected values. The time of the last update is estimated
+// from the block height, assuming 10 minutes per block.
+func (s *hostDBStore) reconcileInteractions(pk types.PublicKey) error {
+	s.mu.Lock()
+	host, exists := s.hosts[pk]
+	if !exists {
+		s.mu.Unlock()
+		return ErrHostNotFound
+	}
+	since := s.heightTime(host.Interactions.LastUpdate)
+	s.mu.Unlock()
+
+	var successes, failures float64
+	for _, table := range []string{"hdb_scans_", "hdb_benchmarks_"} {
+		var succ, fail sql.NullFloat64
+		err := s.db.QueryRow(`
+			SELECT SUM(success), SUM(1 - success)
+			FROM `+table+s.network+`
+			WHERE public_key = ?
+			AND ran_at >= ?
+		`, pk[:], since.Unix()).Scan(&succ, &fail)
+		if err != nil {
+			return utils.AddContext(err, "couldn't count interactions")
+		}
+		successes += succ.Float64
+		failures += fail.Float64
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	host, exists = s.hosts[pk]
+	if !exists {
+		return ErrHostNotFound
+	}
+	host.Interactions.RecentSuccesses = successes
+	host.Interactions.RecentFailures = failures
+	return s.update(host)
+}
+
+// recordPing updates the interactions with the host after a ping.
+func (s *hostDBStore) recordPing(pk types.PublicKey, success bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tx == nil {
+		return ErrStoreClosed
+	}
+	host, exists := s.hosts[pk]
+	if !exists {
+		return ErrHostNotFound
+	}
+	if success {
+		s.hdb.IncrementSuccessfulInteractions(host, pingWeight)
+	} else {
+		s.hdb.IncrementFailedInteractions(host, pingWeight)
+	}
+	return s.update(host)
+}
+
 // updateScanHistory adds a new scan to the host's scan history.
 func (s *hostDBStore) updateScanHistory(host *HostDBEntry, scan HostScan) error {
 	if host.Network != s.network {
@@ -172,7 +498,7 @@ func (s *hostDBStore) updateScanHistory(host *HostDBEntry, scan HostScan) error
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if s.tx == nil {
-		return errors.New("there is no transaction")
+		return ErrStoreClosed
 	}
 
 	if scan.Success {
@@ -215,10 +541,17 @@ func (s *hostDBStore) updateScanHistory(host *HostDBEntry, scan HostScan) error
 			error,
 			settings,
 			price_table,
+			region,
+			rhp3_ttfb,
+			price_table_fetch,
+			warnings,
+			siamux_port,
+			signature,
+			ever_online,
 			modified,
 			fetched
 		)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
 		host.PublicKey[:],
 		scan.Timestamp.Unix(),
@@ -227,6 +560,13 @@ func (s *hostDBStore) updateScanHistory(host *HostDBEntry, scan HostScan) error
 		scan.Error,
 		settings.Bytes(),
 		pt.Bytes(),
+		s.hdb.region,
+		scan.RHP3TTFB.Milliseconds(),
+		scan.PriceTableFetch.Milliseconds(),
+		strings.Join(scan.SettingsWarnings, ";"),
+		scan.SiaMuxPort,
+		scan.Signature[:],
+		scan.EverOnline,
 		time.Now().Unix(),
 		0,
 	)
@@ -256,7 +596,7 @@ func (s *hostDBStore) updateBenchmarks(host *HostDBEntry, benchmark HostBenchmar
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if s.tx == nil {
-		return errors.New("there is no transaction")
+		return ErrStoreClosed
 	}
 
 	host.LastBenchmark = benchmark
@@ -269,10 +609,13 @@ func (s *hostDBStore) updateBenchmarks(host *HostDBEntry, benchmark HostBenchmar
 			download_speed,
 			ttfb,
 			error,
+			error_category,
+			partial,
+			concurrency,
 			modified,
 			fetched
 		)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
 		host.PublicKey[:],
 		benchmark.Timestamp.Unix(),
@@ -281,6 +624,9 @@ func (s *hostDBStore) updateBenchmarks(host *HostDBEntry, benchmark HostBenchmar
 		benchmark.DownloadSpeed,
 		benchmark.TTFB.Milliseconds(),
 		benchmark.Error,
+		benchmark.ErrorCategory,
+		benchmark.Partial,
+		benchmark.Concurrency,
 		time.Now().Unix(),
 		0,
 	)
@@ -296,6 +642,467 @@ func (s *hostDBStore) updateBenchmarks(host *HostDBEntry, benchmark HostBenchmar
 	return nil
 }
 
+// getRawScan returns the settings and the price table of a scan
+// as they are stored in the database.
+func (s *hostDBStore) getRawScan(pk types.PublicKey, timestamp time.Time) (settings, pt []byte, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tx == nil {
+		return nil, nil, ErrStoreClosed
+	}
+
+	err = s.tx.QueryRow(`
+		SELECT settings, price_table
+		FROM hdb_scans_`+s.network+`
+		WHERE public_key = ?
+		AND ran_at = ?
+		ORDER BY id DESC
+		LIMIT 1
+	`, pk[:], timestamp.Unix()).Scan(&settings, &pt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, errors.New("scan not found")
+	}
+	if err != nil {
+		return nil, nil, utils.AddContext(err, "couldn't query scan")
+	}
+
+	return settings, pt, nil
+}
+
+// splitWarnings converts the stored settings warnings into a slice.
+func splitWarnings(warnings string) []string {
+	if warnings == "" {
+		return nil
+	}
+	return strings.Split(warnings, ";")
+}
+
+// decodeScan decodes the stored settings and price table of a scan.
+func decodeScan(scan *HostScan, settings, pt []byte) error {
+	if len(settings) > 0 {
+		d := types.NewBufDecoder(settings)
+		utils.DecodeSettings(&scan.Settings, d)
+		if err := d.Err(); err != nil {
+			return utils.AddContext(err, "couldn't decode host settings")
+		}
+	}
+	if len(pt) > 0 {
+		d := types.NewBufDecoder(pt)
+		utils.DecodePriceTable(&scan.PriceTable, d)
+		if err := d.Err(); err != nil {
+			return utils.AddContext(err, "couldn't decode host price table")
+		}
+	}
+	return nil
+}
+
+// decodeSignature converts the stored signature of a scan. Unsigned scans
+// have an empty signature.
+func decodeSignature(b []byte) (sig types.Signature) {
+	copy(sig[:], b)
+	return
+}
+
+// getScans returns the scans of the host that were run within
+// the given time range, oldest first.
+func (s *hostDBStore) getScans(pk types.PublicKey, from, to time.Time) ([]HostScan, error) {
+	rows, err := s.db.Query(`
+		SELECT id, ran_at, success, latency, error, settings, price_table, rhp3_ttfb, price_table_fetch, warnings, siamux_port, signature, ever_online
+		FROM hdb_scans_`+s.network+`
+		WHERE public_key = ?
+		AND ran_at >= ?
+		AND ran_at <= ?
+		ORDER BY ran_at ASC
+	`, pk[:], from.Unix(), to.Unix())
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't query scans")
+	}
+	defer rows.Close()
+
+	var scans []HostScan
+	for rows.Next() {
+		var id, ra int64
+		var success bool
+		var latency, ttfb, fetch float64
+		var msg, warnings, port string
+		var settings, pt, sig []byte
+		var everOnline bool
+		if err := rows.Scan(&id, &ra, &success, &latency, &msg, &settings, &pt, &ttfb, &fetch, &warnings, &port, &sig, &everOnline); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode scan")
+		}
+		scan := HostScan{
+			ID:               id,
+			Timestamp:        time.Unix(ra, 0),
+			Success:          success,
+			Latency:          time.Duration(latency) * time.Millisecond,
+			Error:            msg,
+			RHP3TTFB:         time.Duration(ttfb) * time.Millisecond,
+			PriceTableFetch:  time.Duration(fetch) * time.Millisecond,
+			SettingsWarnings: splitWarnings(warnings),
+			SiaMuxPort:       port,
+			Signature:        decodeSignature(sig),
+			EverOnline:       everOnline,
+		}
+		if err := decodeScan(&scan, settings, pt); err != nil {
+			return nil, err
+		}
+		scans = append(scans, scan)
+	}
+
+	return scans, nil
+}
+
+// getScansInWindow returns up to limit scans of all hosts that were run
+// within the given time range after the given time and ID, oldest first,
+// in the order they were recorded.
+func (s *hostDBStore) getScansInWindow(from, to time.Time, afterTime, afterID int64, limit int) ([]ScanHistory, error) {
+	rows, err := s.db.Query(`
+		SELECT id, public_key, ran_at, success, latency, error, settings, price_table, rhp3_ttfb, price_table_fetch, warnings, siamux_port, region, signature, ever_online
+		FROM hdb_scans_`+s.network+`
+		WHERE ran_at >= ?
+		AND ran_at <= ?
+		AND (ran_at, id) > (?, ?)
+		ORDER BY ran_at ASC, id ASC
+		LIMIT ?
+	`, from.Unix(), to.Unix(), afterTime, afterID, limit)
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't query scans")
+	}
+	defer rows.Close()
+
+	var scans []ScanHistory
+	for rows.Next() {
+		var id, ra int64
+		var success bool
+		var latency, ttfb, fetch float64
+		var msg, warnings, port, region string
+		var settings, pt, sig []byte
+		var everOnline bool
+		pk := make([]byte, 32)
+		if err := rows.Scan(&id, &pk, &ra, &success, &latency, &msg, &settings, &pt, &ttfb, &fetch, &warnings, &port, &region, &sig, &everOnline); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode scan")
+		}
+		scan := HostScan{
+			ID:               id,
+			Timestamp:        time.Unix(ra, 0),
+			Success:          success,
+			Latency:          time.Duration(latency) * time.Millisecond,
+			Error:            msg,
+			RHP3TTFB:         time.Duration(ttfb) * time.Millisecond,
+			PriceTableFetch:  time.Duration(fetch) * time.Millisecond,
+			SettingsWarnings: splitWarnings(warnings),
+			SiaMuxPort:       port,
+			Signature:        decodeSignature(sig),
+			EverOnline:       everOnline,
+		}
+		if err := decodeScan(&scan, settings, pt); err != nil {
+			return nil, err
+		}
+		scans = append(scans, ScanHistory{
+			HostScan:  scan,
+			PublicKey: types.PublicKey(pk),
+			Network:   s.network,
+			Region:    region,
+		})
+	}
+
+	return scans, nil
+}
+
+// getBenchmarks returns the benchmarks of the host that were run within
+// the given time range, oldest first.
+func (s *hostDBStore) getBenchmarks(pk types.PublicKey, from, to time.Time) ([]HostBenchmark, error) {
+	rows, err := s.db.Query(`
+		SELECT id, ran_at, success, upload_speed, download_speed, ttfb, error, error_category, partial, concurrency
+		FROM hdb_benchmarks_`+s.network+`
+		WHERE public_key = ?
+		AND ran_at >= ?
+		AND ran_at <= ?
+		ORDER BY ran_at ASC
+	`, pk[:], from.Unix(), to.Unix())
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't query benchmarks")
+	}
+	defer rows.Close()
+
+	var benchmarks []HostBenchmark
+	for rows.Next() {
+		var id, ra int64
+		var success, partial bool
+		var ul, dl, ttfb float64
+		var msg, category string
+		var concurrency int
+		if err := rows.Scan(&id, &ra, &success, &ul, &dl, &ttfb, &msg, &category, &partial, &concurrency); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode benchmark")
+		}
+		benchmarks = append(benchmarks, HostBenchmark{
+			ID:            id,
+			Timestamp:     time.Unix(ra, 0),
+			Success:       success,
+			UploadSpeed:   ul,
+			DownloadSpeed: dl,
+			TTFB:          time.Duration(ttfb) * time.Millisecond,
+			Error:         msg,
+			ErrorCategory: category,
+			Partial:       partial,
+			Concurrency:   concurrency,
+		})
+	}
+
+	return benchmarks, nil
+}
+
+// getLatencyTrend returns the average latency of the successful scans of
+// the host within the given time range, grouped by the buckets of the
+// given size. The map is keyed by the bucket index.
+func (s *hostDBStore) getLatencyTrend(pk types.PublicKey, bucket time.Duration, from, to time.Time) (map[int64]time.Duration, error) {
+	size := int64(bucket.Seconds())
+	rows, err := s.db.Query(`
+		SELECT FLOOR((ran_at - ?) / ?) AS b, AVG(latency)
+		FROM hdb_scans_`+s.network+`
+		WHERE public_key = ?
+		AND success = TRUE
+		AND ran_at >= ?
+		AND ran_at < ?
+		GROUP BY b
+	`, from.Unix(), size, pk[:], from.Unix(), to.Unix())
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't query scans")
+	}
+	defer rows.Close()
+
+	latencies := make(map[int64]time.Duration)
+	for rows.Next() {
+		var b int64
+		var latency float64
+		if err := rows.Scan(&b, &latency); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode latency")
+		}
+		latencies[b] = time.Duration(latency * float64(time.Millisecond))
+	}
+
+	return latencies, nil
+}
+
+// getBenchmarkErrors returns the number of failed benchmarks since
+// the given time per error category.
+func (s *hostDBStore) getBenchmarkErrors(since time.Time) (map[string]int, error) {
+	rows, err := s.db.Query(`
+		SELECT error_category, COUNT(*)
+		FROM hdb_benchmarks_`+s.network+`
+		WHERE success = FALSE
+		AND ran_at >= ?
+		GROUP BY error_category
+	`, since.Unix())
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't query benchmarks")
+	}
+	defer rows.Close()
+
+	breakdown := make(map[string]int)
+	for rows.Next() {
+		var category string
+		var count int
+		if err := rows.Scan(&category, &count); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode benchmark errors")
+		}
+		if category == "" {
+			category = BenchmarkErrorOther
+		}
+		breakdown[category] += count
+	}
+
+	return breakdown, nil
+}
+
+// getHostsFailingBenchmarks returns the keys of the hosts that had at
+// least minScans successful scans but failed all of at least minBenchmarks
+// benchmarks since the given time.
+func (s *hostDBStore) getHostsFailingBenchmarks(since time.Time, minScans, minBenchmarks int) ([]types.PublicKey, error) {
+	rows, err := s.db.Query(`
+		SELECT b.public_key
+		FROM hdb_benchmarks_`+s.network+` AS b
+		INNER JOIN (
+			SELECT public_key
+			FROM hdb_scans_`+s.network+`
+			WHERE success = TRUE
+			AND ran_at >= ?
+			GROUP BY public_key
+			HAVING COUNT(*) >= ?
+		) AS sc ON sc.public_key = b.public_key
+		WHERE b.ran_at >= ?
+		AND b.partial = FALSE
+		GROUP BY b.public_key
+		HAVING COUNT(*) >= ? AND SUM(b.success) = 0
+	`, since.Unix(), minScans, since.Unix(), minBenchmarks)
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't query benchmarks")
+	}
+	defer rows.Close()
+
+	var pks []types.PublicKey
+	for rows.Next() {
+		pk := make([]byte, 32)
+		if err := rows.Scan(&pk); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode public key")
+		}
+		pks = append(pks, types.PublicKey(pk))
+	}
+
+	return pks, nil
+}
+
+// getHostsWithFailedScans returns the public keys of the hosts that
+// failed at least one scan since the given time.
+func (s *hostDBStore) getHostsWithFailedScans(since time.Time) ([]types.PublicKey, error) {
+	rows, err := s.db.Query(`
+		SELECT DISTINCT public_key
+		FROM hdb_scans_`+s.network+`
+		WHERE success = FALSE
+		AND ran_at >= ?
+	`, since.Unix())
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't query scans")
+	}
+	defer rows.Close()
+
+	var pks []types.PublicKey
+	for rows.Next() {
+		pk := make([]byte, 32)
+		if err := rows.Scan(&pk); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode public key")
+		}
+		pks = append(pks, types.PublicKey(pk))
+	}
+
+	return pks, nil
+}
+
+// getChurn returns the number of the hosts that were lost and gained
+// within the given time range. A host is lost if it had at least minScans
+// successful scans, the last of which was run within the range, and every
+// scan after that failed. A host is gained if it was first seen within
+// the range and scanned successfully within the same range.
+func (s *hostDBStore) getChurn(from, to time.Time, minScans int) (lost, gained int, err error) {
+	err = s.db.QueryRow(`
+		SELECT COUNT(*)
+		FROM (
+			SELECT a.public_key
+			FROM hdb_scans_`+s.network+` AS a
+			WHERE a.success = TRUE
+			GROUP BY a.public_key
+			HAVING MAX(a.ran_at) >= ?
+			AND MAX(a.ran_at) <= ?
+			AND COUNT(*) >= ?
+			AND MAX(a.ran_at) < (
+				SELECT MAX(b.ran_at)
+				FROM hdb_scans_`+s.network+` AS b
+				WHERE b.public_key = a.public_key
+			)
+		) AS l
+	`, from.Unix(), to.Unix(), minScans).Scan(&lost)
+	if err != nil {
+		return 0, 0, utils.AddContext(err, "couldn't count lost hosts")
+	}
+
+	err = s.db.QueryRow(`
+		SELECT COUNT(DISTINCT sc.public_key)
+		FROM hdb_scans_`+s.network+` AS sc
+		INNER JOIN hdb_hosts_`+s.network+` AS h
+		ON h.public_key = sc.public_key
+		WHERE sc.success = TRUE
+		AND sc.ran_at >= ?
+		AND sc.ran_at <= ?
+		AND h.first_seen >= ?
+		AND h.first_seen <= ?
+	`, from.Unix(), to.Unix(), from.Unix(), to.Unix()).Scan(&gained)
+	if err != nil {
+		return 0, 0, utils.AddContext(err, "couldn't count gained hosts")
+	}
+
+	return
+}
+
+// getAsymmetricHosts returns the keys of the hosts that were scanned
+// successfully at least at the given rate but passed fewer benchmarks
+// than the given rate since the given time, with most of the failures
+// occurring while transferring the data.
+func (s *hostDBStore) getAsymmetricHosts(since time.Time, minScans, minBenchmarks int, scanRate, benchmarkRate float64) ([]types.PublicKey, error) {
+	rows, err := s.db.Query(`
+		SELECT b.public_key
+		FROM hdb_benchmarks_`+s.network+` AS b
+		INNER JOIN (
+			SELECT public_key
+			FROM hdb_scans_`+s.network+`
+			WHERE ran_at >= ?
+			GROUP BY public_key
+			HAVING COUNT(*) >= ? AND AVG(success) >= ?
+		) AS sc ON sc.public_key = b.public_key
+		WHERE b.ran_at >= ?
+		GROUP BY b.public_key
+		HAVING COUNT(*) >= ?
+		AND AVG(b.success) < ?
+		AND SUM(b.success = FALSE AND b.error_category IN (?, ?, ?)) * 2 > SUM(b.success = FALSE)
+	`,
+		since.Unix(), minScans, scanRate,
+		since.Unix(), minBenchmarks, benchmarkRate,
+		BenchmarkErrorUpload, BenchmarkErrorDownload, BenchmarkErrorTimeout,
+	)
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't query benchmarks")
+	}
+	defer rows.Close()
+
+	var pks []types.PublicKey
+	for rows.Next() {
+		pk := make([]byte, 32)
+		if err := rows.Scan(&pk); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode public key")
+		}
+		pks = append(pks, types.PublicKey(pk))
+	}
+
+	return pks, nil
+}
+
+// ipChange is a change of the host's address.
+type ipChange struct {
+	publicKey types.PublicKey
+	changedAt time.Time
+	ipNets    []string
+}
+
+// getIPChanges returns the address changes since the given time.
+func (s *hostDBStore) getIPChanges(since time.Time) ([]ipChange, error) {
+	rows, err := s.db.Query(`
+		SELECT public_key, changed_at, ip_nets
+		FROM hdb_ip_changes_`+s.network+`
+		WHERE changed_at >= ?
+		ORDER BY changed_at ASC
+	`, since.Unix())
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't query address changes")
+	}
+	defer rows.Close()
+
+	var changes []ipChange
+	for rows.Next() {
+		pk := make([]byte, 32)
+		var ca int64
+		var ipNets string
+		if err := rows.Scan(&pk, &ca, &ipNets); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode address change")
+		}
+		changes = append(changes, ipChange{
+			publicKey: types.PublicKey(pk),
+			changedAt: time.Unix(ca, 0),
+			ipNets:    strings.Split(ipNets, ";"),
+		})
+	}
+
+	return changes, nil
+}
+
 // lastFailedScans returns the number of scans failed in a row.
 // NOTE: a lock must be acquired before calling this function.
 func (s *hostDBStore) lastFailedScans(host *HostDBEntry) int {
@@ -355,6 +1162,7 @@ func (s *hostDBStore) lastFailedBenchmarks(host *HostDBEntry) int {
 		FROM hdb_benchmarks_`+s.network+` AS a
 		WHERE a.public_key = ?
 		AND a.success = FALSE
+		AND a.partial = FALSE
 		AND (
 			a.ran_at > (
 				SELECT b.ran_at
@@ -380,6 +1188,24 @@ func (s *hostDBStore) lastFailedBenchmarks(host *HostDBEntry) int {
 	return count
 }
 
+// flush commits any pending writes and starts a new transaction.
+func (s *hostDBStore) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tx == nil {
+		return nil
+	}
+
+	if err := s.tx.Commit(); err != nil {
+		return utils.AddContext(err, "couldn't commit transaction")
+	}
+	s.lastCommitted = time.Now()
+
+	var err error
+	s.tx, err = s.db.Begin()
+	return utils.AddContext(err, "couldn't begin transaction")
+}
+
 func (s *hostDBStore) close() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -387,6 +1213,7 @@ func (s *hostDBStore) close() {
 		if err := s.tx.Commit(); err != nil {
 			s.log.Error("couldn't commit transaction", zap.String("network", s.network), zap.Error(err))
 		}
+		s.tx = nil
 	}
 }
 
@@ -429,7 +1256,11 @@ func (s *hostDBStore) load(domains *blockedDomains) error {
 			last_update,
 			revision,
 			settings,
-			price_table
+			price_table,
+			scan_interval,
+			priority,
+			favorite,
+			previous_keys
 		FROM hdb_hosts_` + s.network,
 	)
 	if err != nil {
@@ -437,31 +1268,36 @@ func (s *hostDBStore) load(domains *blockedDomains) error {
 	}
 
 	for rows.Next() {
-		var id int
+		var id, pr int
 		pk := make([]byte, 32)
 		var ks, lu uint64
-		var b bool
-		var na, ip string
-		var ut, dt, fs, ls, lc int64
+		var b, fav bool
+		var na, ip, prev string
+		var ut, dt, fs, ls, lc, si int64
 		var hsi, hfi, rsi, rfi float64
 		var rev, settings, pt []byte
-		if err := rows.Scan(&id, &pk, &fs, &ks, &b, &na, &ut, &dt, &ls, &ip, &lc, &hsi, &hfi, &rsi, &rfi, &lu, &rev, &settings, &pt); err != nil {
+		if err := rows.Scan(&id, &pk, &fs, &ks, &b, &na, &ut, &dt, &ls, &ip, &lc, &hsi, &hfi, &rsi, &rfi, &lu, &rev, &settings, &pt, &si, &pr, &fav, &prev); err != nil {
 			rows.Close()
 			return utils.AddContext(err, "couldn't scan host data")
 		}
 		host := &HostDBEntry{
-			ID:           id,
-			PublicKey:    types.PublicKey(pk),
-			Network:      s.network,
-			FirstSeen:    time.Unix(fs, 0),
-			KnownSince:   ks,
-			Blocked:      b,
-			NetAddress:   na,
-			Uptime:       time.Duration(ut) * time.Second,
-			Downtime:     time.Duration(dt) * time.Second,
-			LastSeen:     time.Unix(ls, 0),
-			IPNets:       strings.Split(ip, ";"),
-			LastIPChange: time.Unix(lc, 0),
+			ID:             id,
+			PublicKey:      types.PublicKey(pk),
+			Network:        s.network,
+			FirstSeen:      time.Unix(fs, 0),
+			KnownSince:     ks,
+			Blocked:        b,
+			NetAddress:     na,
+			Uptime:         time.Duration(ut) * time.Second,
+			Downtime:       time.Duration(dt) * time.Second,
+			LastSeen:       time.Unix(ls, 0),
+			IPNets:         strings.Split(ip, ";"),
+			LastIPChange:   time.Unix(lc, 0),
+			ScanInterval:   time.Duration(si) * time.Second,
+			Priority:       pr,
+			Favorite:       fav,
+			PreviousKeys:   decodeKeys(prev),
+			PrivateAddress: utils.IsPrivateIPNets(strings.Split(ip, ";")),
 			Interactions: HostInteractions{
 				HistoricSuccesses: hsi,
 				HistoricFailures:  hfi,
@@ -490,16 +1326,21 @@ func (s *hostDBStore) load(domains *blockedDomains) error {
 				return utils.AddContext(err, "couldn't decode host price table")
 			}
 		}
-		if host.Blocked || domains.isBlocked(host.NetAddress) {
+		if host.Blocked || domains.isBlocked(host.NetAddress) || domains.isKeyBlocked(host.PublicKey) {
 			host.Blocked = true
 			s.blockedHosts[host.PublicKey] = struct{}{}
 		}
 		s.hosts[host.PublicKey] = host
+		s.addresses[host.NetAddress] = host.PublicKey
+		s.ipChanges[host.PublicKey] = host.LastIPChange
+		if id > s.maxID {
+			s.maxID = id
+		}
 	}
 	rows.Close()
 
 	scanStmt, err := s.db.Prepare(`
-		SELECT ran_at, success, latency, error, settings, price_table
+		SELECT ran_at, success, latency, error, settings, price_table, rhp3_ttfb, price_table_fetch, warnings, siamux_port, signature, ever_online
 		FROM hdb_scans_` + s.network + `
 		WHERE public_key = ?
 		ORDER BY ran_at DESC
@@ -537,7 +1378,7 @@ func (s *hostDBStore) load(domains *blockedDomains) error {
 	defer priceTableStmt.Close()
 
 	benchmarkStmt, err := s.db.Prepare(`
-		SELECT ran_at, success, upload_speed, download_speed, ttfb, error
+		SELECT ran_at, success, upload_speed, download_speed, ttfb, error, error_category, partial, concurrency
 		FROM hdb_benchmarks_` + s.network + `
 		WHERE public_key = ?
 		ORDER BY ran_at DESC
@@ -556,18 +1397,25 @@ func (s *hostDBStore) load(domains *blockedDomains) error {
 		for rows.Next() {
 			var ra int64
 			var success bool
-			var latency float64
-			var msg string
-			var settings, pt []byte
-			if err := rows.Scan(&ra, &success, &latency, &msg, &settings, &pt); err != nil {
+			var latency, ttfb, fetch float64
+			var msg, warnings, port string
+			var settings, pt, sig []byte
+			var everOnline bool
+			if err := rows.Scan(&ra, &success, &latency, &msg, &settings, &pt, &ttfb, &fetch, &warnings, &port, &sig, &everOnline); err != nil {
 				rows.Close()
 				return utils.AddContext(err, "couldn't load scan history")
 			}
 			scan := HostScan{
-				Timestamp: time.Unix(ra, 0),
-				Success:   success,
-				Latency:   time.Duration(latency) * time.Millisecond,
-				Error:     msg,
+				Timestamp:        time.Unix(ra, 0),
+				Success:          success,
+				Latency:          time.Duration(latency) * time.Millisecond,
+				Error:            msg,
+				RHP3TTFB:         time.Duration(ttfb) * time.Millisecond,
+				PriceTableFetch:  time.Duration(fetch) * time.Millisecond,
+				SettingsWarnings: splitWarnings(warnings),
+				SiaMuxPort:       port,
+				Signature:        decodeSignature(sig),
+				EverOnline:       everOnline,
 			}
 			if len(settings) > 0 {
 				d := types.NewBufDecoder(settings)
@@ -620,10 +1468,11 @@ func (s *hostDBStore) load(domains *blockedDomains) error {
 		}
 
 		var ra int64
-		var success bool
+		var success, partial bool
 		var ul, dl, ttfb float64
-		var msg string
-		err = benchmarkStmt.QueryRow(host.PublicKey[:]).Scan(&ra, &success, &ul, &dl, &ttfb, &msg)
+		var msg, category string
+		var concurrency int
+		err = benchmarkStmt.QueryRow(host.PublicKey[:]).Scan(&ra, &success, &ul, &dl, &ttfb, &msg, &category, &partial, &concurrency)
 		if err != nil && !errors.Is(err, sql.ErrNoRows) {
 			return utils.AddContext(err, "couldn't load benchmarks")
 		}
@@ -635,6 +1484,9 @@ func (s *hostDBStore) load(domains *blockedDomains) error {
 				DownloadSpeed: dl,
 				TTFB:          time.Duration(ttfb) * time.Millisecond,
 				Error:         msg,
+				ErrorCategory: category,
+				Partial:       partial,
+				Concurrency:   concurrency,
 			}
 		}
 		if (len(host.ScanHistory) > 0 && host.ScanHistory[len(host.ScanHistory)-1].Success) && (len(host.ScanHistory) > 1 && host.ScanHistory[len(host.ScanHistory)-2].Success || len(host.ScanHistory) == 1) {
@@ -655,11 +1507,102 @@ func (s *hostDBStore) isSynced() bool {
 	return isSynced(s.hdb.syncer)
 }
 
+// revertChainUpdate reverts a chain update. The hosts that were first
+// announced in the reverted block are removed, because they have no other
+// announcement. The hosts announced earlier are kept, even though their
+// address may have been changed by the reverted announcement; the next
+// announcement or scan will correct it.
+// NOTE: a lock must be acquired before calling revertChainUpdate.
+func (s *hostDBStore) revertChainUpdate(cru chain.RevertUpdate) error {
+	s.tip = cru.State.Index
+	row := 1
+	if s.network == "zen" {
+		row = 2
+	}
+	_, err := s.tx.Exec(`
+		REPLACE INTO hdb_tip (id, network, height, bid)
+		VALUES (?, ?, ?, ?)
+	`, row, s.network, s.tip.Height, s.tip.ID[:])
+	if err != nil {
+		return utils.AddContext(err, "couldn't update tip")
+	}
+
+	height := cru.State.Index.Height + 1
+	anns := filterAnnouncements(blockAnnouncements(cru.Block))
+	for i := len(anns) - 1; i >= 0; i-- {
+		host, exists := s.hosts[anns[i].publicKey]
+		if !exists || host.KnownSince != height {
+			continue
+		}
+		if err := s.removeHost(host); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newHost returns a new host entry with a fresh ID. If the host was
+// removed before, the removal is not reported anymore.
+// NOTE: a lock must be acquired before calling newHost.
+func (s *hostDBStore) newHost(pk types.PublicKey) (*HostDBEntry, error) {
+	_, err := s.tx.Exec(`
+		DELETE FROM hdb_removed_`+s.network+`
+		WHERE public_key = ?
+	`, pk[:])
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't delete removal")
+	}
+	s.maxID++
+	return &HostDBEntry{
+		ID:        s.maxID,
+		Network:   s.network,
+		PublicKey: pk,
+	}, nil
+}
+
+// removeHost removes the host and all its records from the database.
+// The removal is reported with the next updates.
+// NOTE: a lock must be acquired before calling removeHost.
+func (s *hostDBStore) removeHost(host *HostDBEntry) error {
+	for _, table := range []string{"scans", "rollups", "benchmarks", "ip_changes", "notes", "hosts"} {
+		_, err := s.tx.Exec("DELETE FROM hdb_"+table+"_"+s.network+" WHERE public_key = ?", host.PublicKey[:])
+		if err != nil {
+			return utils.AddContext(err, "couldn't delete from "+table)
+		}
+	}
+	_, err := s.tx.Exec(`
+		REPLACE INTO hdb_removed_`+s.network+` (public_key, removed_at)
+		VALUES (?, ?)
+	`, host.PublicKey[:], time.Now().Unix())
+	if err != nil {
+		return utils.AddContext(err, "couldn't record removal")
+	}
+	delete(s.hosts, host.PublicKey)
+	delete(s.blockedHosts, host.PublicKey)
+	delete(s.activeHostsCache, host.PublicKey)
+	if s.addresses[host.NetAddress] == host.PublicKey {
+		delete(s.addresses, host.NetAddress)
+	}
+	s.hdb.dequeue(host.PublicKey)
+	return nil
+}
+
 // updateChainState applies the chain manager updates.
-func (s *hostDBStore) updateChainState(applied []chain.ApplyUpdate, mayCommit bool) error {
+func (s *hostDBStore) updateChainState(reverted []chain.RevertUpdate, applied []chain.ApplyUpdate, mayCommit bool) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	for _, cru := range reverted {
+		if s.network != cru.State.Network.Name {
+			continue
+		}
+		if err := s.revertChainUpdate(cru); err != nil {
+			s.log.Error("couldn't revert chain update", zap.String("network", s.network), zap.Error(err))
+			return err
+		}
+	}
+
 	for _, cau := range applied {
 		if s.network != cau.State.Network.Name {
 			continue
@@ -679,87 +1622,43 @@ func (s *hostDBStore) updateChainState(applied []chain.ApplyUpdate, mayCommit bo
 			return err
 		}
 
-		for _, txn := range cau.Block.Transactions {
-			for _, ad := range txn.ArbitraryData {
-				addr, pk, err := decodeAnnouncement(ad)
-				if err != nil {
-					// Not a valid host announcement.
-					continue
-				}
-				if err := utils.IsValid(addr); err != nil {
-					// Invalid netaddress.
-					continue
-				}
-				if utils.IsLocal(addr) {
-					// Local netaddress.
-					continue
-				}
-				host, exists := s.hosts[pk]
-				if !exists {
-					host = &HostDBEntry{
-						ID:         len(s.hosts) + 1,
-						Network:    s.network,
-						PublicKey:  pk,
-						FirstSeen:  cau.Block.Timestamp,
-						KnownSince: cau.State.Index.Height,
-					}
-				}
-				host.NetAddress = addr
-				ipNets, err := utils.LookupIPNets(addr)
-				if err == nil && !utils.EqualIPNets(ipNets, host.IPNets) {
-					host.IPNets = ipNets
-					host.LastIPChange = cau.Block.Timestamp
-				}
-				err = s.update(host)
-				if err != nil {
-					s.log.Error("couldn't update host", zap.String("network", s.network), zap.Error(err))
-					return err
-				}
-				if (!exists || s.isSynced()) && !host.Blocked {
-					s.hdb.queueScan(host)
-				}
+		// Collect the announcements of the block and resolve their
+		// addresses concurrently, because the name lookups are the
+		// slowest part. The hosts are then updated in order.
+		anns := filterAnnouncements(blockAnnouncements(cau.Block))
+		resolveAnnouncements(anns, s.hdb.announcementWorkers)
+
+		for _, ann := range anns {
+			addr, pk := ann.netAddress, ann.publicKey
+			host, exists := s.hosts[pk]
+			ipNets, err := ann.ipNets, ann.err
+			if err == nil && s.hdb.ignoredSubnets.isIgnored(ipNets) {
+				// Announced from an ignored subnet.
+				continue
 			}
-		}
-
-		for _, txn := range cau.Block.V2Transactions() {
-			for _, at := range txn.Attestations {
-				addr, pk, err := decodeV2Announcement(at)
+			if !exists {
+				var err error
+				host, err = s.newHost(pk)
 				if err != nil {
-					// Not a valid host announcement.
-					continue
-				}
-				if err := utils.IsValid(addr); err != nil {
-					// Invalid netaddress.
-					continue
-				}
-				if utils.IsLocal(addr) {
-					// Local netaddress.
-					continue
-				}
-				host, exists := s.hosts[pk]
-				if !exists {
-					host = &HostDBEntry{
-						ID:         len(s.hosts) + 1,
-						Network:    s.network,
-						PublicKey:  pk,
-						FirstSeen:  cau.Block.Timestamp,
-						KnownSince: cau.State.Index.Height,
-					}
-				}
-				host.NetAddress = addr
-				ipNets, err := utils.LookupIPNets(addr)
-				if err == nil && !utils.EqualIPNets(ipNets, host.IPNets) {
-					host.IPNets = ipNets
-					host.LastIPChange = cau.Block.Timestamp
-				}
-				err = s.update(host)
-				if err != nil {
-					s.log.Error("couldn't update host", zap.String("network", s.network), zap.Error(err))
+					s.log.Error("couldn't add host", zap.String("network", s.network), zap.Error(err))
 					return err
 				}
-				if (!exists || s.isSynced()) && !host.Blocked {
-					s.hdb.queueScan(host)
-				}
+				host.FirstSeen = cau.Block.Timestamp
+				host.KnownSince = cau.State.Index.Height
+				host.PreviousKeys = s.previousKeys(addr, pk)
+			}
+			host.NetAddress = addr
+			if err == nil && !utils.EqualIPNets(ipNets, host.IPNets) {
+				host.IPNets = ipNets
+				host.LastIPChange = cau.Block.Timestamp
+			}
+			err = s.update(host)
+			if err != nil {
+				s.log.Error("couldn't update host", zap.String("network", s.network), zap.Error(err))
+				return err
+			}
+			if (!exists || s.isSynced()) && !host.Blocked {
+				s.hdb.queueScan(host)
 			}
 		}
 	}
@@ -811,7 +1710,7 @@ func (s *hostDBStore) checkSubnets(ipNets []string) int {
 func (s *hostDBStore) getRecentUpdates(id UpdateID) (updates HostUpdates, err error) {
 	if s.tx == nil {
 		s.log.Error("there is no transaction", zap.String("network", s.network))
-		return HostUpdates{}, errors.New("no database transaction")
+		return HostUpdates{}, ErrStoreClosed
 	}
 
 	s.mu.Lock()
@@ -841,7 +1740,7 @@ func (s *hostDBStore) getRecentUpdates(id UpdateID) (updates HostUpdates, err er
 	rows.Close()
 
 	rows, err = s.tx.Query(`
-		SELECT s.id, s.public_key, s.ran_at, s.success, s.latency, s.error, s.settings, s.price_table
+		SELECT s.id, s.public_key, s.ran_at, s.success, s.latency, s.error, s.settings, s.price_table, s.rhp3_ttfb, s.price_table_fetch, s.warnings, s.siamux_port, s.region, s.signature, s.ever_online
 		FROM hdb_scans_` + s.network + ` s
 		JOIN hdb_hosts_` + s.network + ` h
 		ON s.public_key = h.public_key
@@ -857,24 +1756,32 @@ func (s *hostDBStore) getRecentUpdates(id UpdateID) (updates HostUpdates, err er
 	for rows.Next() {
 		var id, ra int64
 		var success bool
-		var latency float64
-		var msg string
-		var settings, pt []byte
+		var latency, ttfb, fetch float64
+		var msg, warnings, port, region string
+		var settings, pt, sig []byte
+		var everOnline bool
 		pk := make([]byte, 32)
-		if err := rows.Scan(&id, &pk, &ra, &success, &latency, &msg, &settings, &pt); err != nil {
+		if err := rows.Scan(&id, &pk, &ra, &success, &latency, &msg, &settings, &pt, &ttfb, &fetch, &warnings, &port, &region, &sig, &everOnline); err != nil {
 			rows.Close()
 			return HostUpdates{}, utils.AddContext(err, "couldn't decode scans")
 		}
 		scan := ScanHistory{
 			HostScan: HostScan{
-				ID:        id,
-				Timestamp: time.Unix(ra, 0),
-				Success:   success,
-				Latency:   time.Duration(latency) * time.Millisecond,
-				Error:     msg,
+				ID:               id,
+				Timestamp:        time.Unix(ra, 0),
+				Success:          success,
+				Latency:          time.Duration(latency) * time.Millisecond,
+				Error:            msg,
+				RHP3TTFB:         time.Duration(ttfb) * time.Millisecond,
+				PriceTableFetch:  time.Duration(fetch) * time.Millisecond,
+				SettingsWarnings: splitWarnings(warnings),
+				SiaMuxPort:       port,
+				Signature:        decodeSignature(sig),
+				EverOnline:       everOnline,
 			},
 			PublicKey: types.PublicKey(pk),
 			Network:   s.network,
+			Region:    region,
 		}
 		if len(settings) > 0 {
 			d := types.NewBufDecoder(settings)
@@ -897,7 +1804,7 @@ func (s *hostDBStore) getRecentUpdates(id UpdateID) (updates HostUpdates, err er
 	rows.Close()
 
 	rows, err = s.tx.Query(`
-		SELECT b.id, b.public_key, b.ran_at, b.success, b.upload_speed, b.download_speed, b.ttfb, b.error
+		SELECT b.id, b.public_key, b.ran_at, b.success, b.upload_speed, b.download_speed, b.ttfb, b.error, b.error_category, b.partial, b.concurrency
 		FROM hdb_benchmarks_` + s.network + ` b
 		JOIN hdb_hosts_` + s.network + ` h
 		ON b.public_key = h.public_key
@@ -912,11 +1819,12 @@ func (s *hostDBStore) getRecentUpdates(id UpdateID) (updates HostUpdates, err er
 
 	for rows.Next() {
 		var id, ra int64
-		var success bool
+		var success, partial bool
 		var ul, dl, ttfb float64
-		var msg string
+		var msg, category string
+		var concurrency int
 		pk := make([]byte, 32)
-		if err := rows.Scan(&id, &pk, &ra, &success, &ul, &dl, &ttfb, &msg); err != nil {
+		if err := rows.Scan(&id, &pk, &ra, &success, &ul, &dl, &ttfb, &msg, &category, &partial, &concurrency); err != nil {
 			rows.Close()
 			return HostUpdates{}, utils.AddContext(err, "couldn't decode benchmarks")
 		}
@@ -929,6 +1837,9 @@ func (s *hostDBStore) getRecentUpdates(id UpdateID) (updates HostUpdates, err er
 				DownloadSpeed: dl,
 				TTFB:          time.Duration(ttfb) * time.Millisecond,
 				Error:         msg,
+				ErrorCategory: category,
+				Partial:       partial,
+				Concurrency:   concurrency,
 			},
 			PublicKey: types.PublicKey(pk),
 			Network:   s.network,
@@ -937,6 +1848,29 @@ func (s *hostDBStore) getRecentUpdates(id UpdateID) (updates HostUpdates, err er
 	}
 	rows.Close()
 
+	rows, err = s.tx.Query(`
+		SELECT public_key
+		FROM hdb_removed_` + s.network + `
+		ORDER BY removed_at ASC
+		LIMIT 1000
+	`)
+	if err != nil {
+		return HostUpdates{}, utils.AddContext(err, "couldn't query removed hosts")
+	}
+
+	for rows.Next() {
+		pk := make([]byte, 32)
+		if err := rows.Scan(&pk); err != nil {
+			rows.Close()
+			return HostUpdates{}, utils.AddContext(err, "couldn't decode removed host")
+		}
+		updates.Removed = append(updates.Removed, RemovedHost{
+			PublicKey: types.PublicKey(pk),
+			Network:   s.network,
+		})
+	}
+	rows.Close()
+
 	updates.ID = id
 	s.lastUpdate = updates
 
@@ -951,7 +1885,7 @@ func (s *hostDBStore) finalizeUpdates(id UpdateID) error {
 
 	if s.tx == nil {
 		s.log.Error("there is no transaction", zap.String("network", s.network))
-		return errors.New("no database transaction")
+		return ErrStoreClosed
 	}
 
 	s.mu.Lock()
@@ -1020,6 +1954,19 @@ func (s *hostDBStore) finalizeUpdates(id UpdateID) error {
 	}
 	benchmarkStmt.Close()
 
+	for _, removed := range s.lastUpdate.Removed {
+		if removed.Network != s.network {
+			continue
+		}
+		_, err := s.tx.Exec(`
+			DELETE FROM hdb_removed_`+s.network+`
+			WHERE public_key = ?
+		`, removed.PublicKey[:])
+		if err != nil {
+			return utils.AddContext(err, "couldn't delete removal")
+		}
+	}
+
 	s.lastUpdate = HostUpdates{}
 
 	if err := s.tx.Commit(); err != nil {
@@ -1030,28 +1977,58 @@ func (s *hostDBStore) finalizeUpdates(id UpdateID) error {
 	return err
 }
 
+// subnetKey returns the lowest of the host's subnets, which is used to
+// group the hosts by subnet.
+func subnetKey(host *HostDBEntry) string {
+	var key string
+	for i, ipNet := range host.IPNets {
+		if i == 0 || ipNet < key {
+			key = ipNet
+		}
+	}
+	return key
+}
+
 func (s *hostDBStore) getHostsForScan() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	var hosts []*HostDBEntry
 	for _, host := range s.hosts {
-		if host.Blocked {
+		if host.Blocked || s.hdb.ignoredSubnets.isIgnored(host.IPNets) {
 			continue
 		}
-		if len(host.ScanHistory) == 0 || time.Since(host.ScanHistory[len(host.ScanHistory)-1].Timestamp) >= s.calculateScanInterval(host) {
-			s.hdb.queueScan(host)
+		if len(host.ScanHistory) == 0 || time.Since(lastRefresh(host)) >= s.calculateScanInterval(host) {
+			hosts = append(hosts, host)
 			continue
 		}
-		t := host.LastBenchmark.Timestamp
-		if (t.IsZero() || time.Since(t) >= s.calculateBenchmarkInterval(host)) &&
-			(len(host.ScanHistory) > 0 && host.ScanHistory[len(host.ScanHistory)-1].Success) {
-			s.hdb.queueScan(host)
+		if s.benchmarkDue(host) {
+			hosts = append(hosts, host)
 		}
 	}
+
+	// Higher-priority hosts are scanned first. Favorites go first
+	// among the hosts with the same priority. If enabled, the rest are
+	// grouped by subnet.
+	sort.SliceStable(hosts, func(i, j int) bool {
+		if hosts[i].Priority != hosts[j].Priority {
+			return hosts[i].Priority > hosts[j].Priority
+		}
+		if hosts[i].Favorite != hosts[j].Favorite {
+			return hosts[i].Favorite
+		}
+		if s.hdb.groupBySubnet {
+			return subnetKey(hosts[i]) < subnetKey(hosts[j])
+		}
+		return false
+	})
+	for _, host := range hosts {
+		s.hdb.queueScan(host)
+	}
 }
 
 func (s *hostDBStore) pruneOldRecords() error {
 	if s.tx == nil {
-		return errors.New("no database transaction")
+		return ErrStoreClosed
 	}
 
 	s.mu.Lock()
@@ -1060,11 +2037,21 @@ func (s *hostDBStore) pruneOldRecords() error {
 	_, err := s.tx.Exec(`
 		DELETE FROM hdb_scans_`+s.network+`
 		WHERE ran_at < ?
-	`, time.Now().AddDate(0, 0, -7).Unix())
+		AND success = TRUE
+	`, time.Now().AddDate(0, 0, -s.hdb.successfulScanRetention).Unix())
 	if err != nil {
 		return utils.AddContext(err, "couldn't delete old scans")
 	}
 
+	_, err = s.tx.Exec(`
+		DELETE FROM hdb_scans_`+s.network+`
+		WHERE ran_at < ?
+		AND success = FALSE
+	`, time.Now().AddDate(0, 0, -s.hdb.failedScanRetention).Unix())
+	if err != nil {
+		return utils.AddContext(err, "couldn't delete old failed scans")
+	}
+
 	_, err = s.tx.Exec(`
 		DELETE FROM hdb_benchmarks_`+s.network+`
 		WHERE ran_at < ?
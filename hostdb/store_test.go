@@ -0,0 +1,161 @@
+package hostdb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+	"go.uber.org/zap"
+)
+
+// testDriver is a database driver that accepts every statement and
+// records the executed ones. Queries return no rows.
+type testDriver struct {
+	mu         sync.Mutex
+	statements map[string][]string
+}
+
+var recorder = &testDriver{statements: make(map[string][]string)}
+
+func init() {
+	sql.Register("hostdbtest", recorder)
+}
+
+func (d *testDriver) Open(name string) (driver.Conn, error) { return testConn{d, name}, nil }
+
+// executed returns true if a statement containing the text was run
+// against the named database.
+func (d *testDriver) executed(name, text string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, stmt := range d.statements[name] {
+		if strings.Contains(stmt, text) {
+			return true
+		}
+	}
+	return false
+}
+
+type testConn struct {
+	d    *testDriver
+	name string
+}
+
+func (c testConn) Prepare(query string) (driver.Stmt, error) { return testStmt{c, query}, nil }
+func (c testConn) Close() error                              { return nil }
+func (c testConn) Begin() (driver.Tx, error)                 { return testTx{}, nil }
+
+type testTx struct{}
+
+func (testTx) Commit() error   { return nil }
+func (testTx) Rollback() error { return nil }
+
+type testStmt struct {
+	c     testConn
+	query string
+}
+
+func (s testStmt) Close() error  { return nil }
+func (s testStmt) NumInput() int { return -1 }
+
+func (s testStmt) Exec([]driver.Value) (driver.Result, error) {
+	s.c.d.mu.Lock()
+	s.c.d.statements[s.c.name] = append(s.c.d.statements[s.c.name], s.query)
+	s.c.d.mu.Unlock()
+	return driver.RowsAffected(0), nil
+}
+
+func (s testStmt) Query([]driver.Value) (driver.Rows, error) { return testRows{}, nil }
+
+type testRows struct{}
+
+func (testRows) Columns() []string         { return nil }
+func (testRows) Close() error              { return nil }
+func (testRows) Next([]driver.Value) error { return io.EOF }
+
+// newTestStore attaches a recording database to both stores of the
+// HostDB, and returns the Mainnet one.
+func newTestStore(t *testing.T, hdb *HostDB) *hostDBStore {
+	db, err := sql.Open("hostdbtest", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	hdb.blockedDomains = newBlockedDomains(nil)
+	for _, s := range []*hostDBStore{hdb.s, hdb.sZen} {
+		s.db = db
+		if s.tx, err = db.Begin(); err != nil {
+			t.Fatal(err)
+		}
+		s.log = zap.NewNop()
+		s.hdb = hdb
+		s.blockedHosts = make(map[types.PublicKey]struct{})
+		s.activeHostsCache = make(map[types.PublicKey][]string)
+		s.ipChanges = make(map[types.PublicKey]time.Time)
+		s.addresses = make(map[string]types.PublicKey)
+	}
+	return hdb.s
+}
+
+// TestRevertAnnouncement checks that a reorg removes the hosts first
+// announced in the reverted block, whatever their IDs, and that the
+// removals are recorded.
+func TestRevertAnnouncement(t *testing.T) {
+	hdb := newTestHostDB()
+	s := newTestStore(t, hdb)
+
+	const height = 100
+	keys := make([]types.PrivateKey, 3)
+	var block types.Block
+	for i := range keys {
+		keys[i] = types.GeneratePrivateKey()
+		host := &HostDBEntry{
+			ID:         i + 1,
+			Network:    "mainnet",
+			PublicKey:  keys[i].PublicKey(),
+			KnownSince: height - 1,
+		}
+		if i == 1 {
+			// Only the host in the middle was first announced in the
+			// reverted block.
+			host.KnownSince = height
+		}
+		s.hosts[host.PublicKey] = host
+		s.maxID = host.ID
+		block.Transactions = append(block.Transactions, types.Transaction{
+			ArbitraryData: [][]byte{signedAnnouncement(keys[i], "host.example.com:9982")},
+		})
+	}
+
+	cru := chain.RevertUpdate{Block: block}
+	cru.State.Index.Height = height - 1
+	if err := s.revertChainUpdate(cru); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, sk := range keys {
+		_, exists := s.hosts[sk.PublicKey()]
+		if exists == (i == 1) {
+			t.Errorf("host %d: expected exists = %v", i+1, i != 1)
+		}
+	}
+	if !recorder.executed(t.Name(), "REPLACE INTO hdb_removed_mainnet") {
+		t.Error("removal not recorded")
+	}
+
+	// A new host must not reuse an existing ID.
+	host, err := s.newHost(types.GeneratePrivateKey().PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host.ID != 4 {
+		t.Errorf("expected ID 4, got %d", host.ID)
+	}
+}
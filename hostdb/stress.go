@@ -0,0 +1,122 @@
+package hostdb
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/mike76-dev/hostscore/rhp"
+	rhpv2 "go.sia.tech/core/rhp/v2"
+	"go.sia.tech/core/types"
+)
+
+// maxStressConcurrency is the maximum number of concurrent connections
+// a stress test can open.
+const maxStressConcurrency = 100
+
+// StressResult contains the results of a stress test.
+type StressResult struct {
+	Concurrency     int           `json:"concurrency"`
+	Successes       int           `json:"successes"`
+	Failures        int           `json:"failures"`
+	BaselineLatency time.Duration `json:"baselineLatency"`
+	AverageLatency  time.Duration `json:"averageLatency"`
+	MaxLatency      time.Duration `json:"maxLatency"`
+
+	// Degradation is the ratio of the average latency under load to
+	// the baseline latency.
+	Degradation float64 `json:"degradation"`
+}
+
+// StressTest measures how the host handles load. It first fetches the
+// host settings over a single RHP2 connection to get the baseline
+// latency, then does the same over concurrency connections opened at
+// the same time. The results are not recorded in the scan history.
+func (hdb *HostDB) StressTest(pk types.PublicKey, concurrency int) (StressResult, error) {
+	if err := hdb.tg.Add(); err != nil {
+		return StressResult{}, err
+	}
+	defer hdb.tg.Done()
+
+	s, exists := hdb.hostStore(pk)
+	if !exists {
+		return StressResult{}, ErrHostNotFound
+	}
+	host, exists := s.hostEntry(pk)
+	if !exists {
+		return StressResult{}, ErrHostNotFound
+	}
+	if host.PrivateAddress && !hdb.scanPrivateAddresses {
+		return StressResult{}, errPrivateAddress
+	}
+	if concurrency <= 0 {
+		return StressResult{}, errors.New("concurrency must be positive")
+	}
+	if concurrency > maxStressConcurrency {
+		concurrency = maxStressConcurrency
+	}
+
+	hdb.mu.Lock()
+	timeout := hdb.scanTimeout
+	hdb.mu.Unlock()
+
+	fetch := func() (time.Duration, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		go func() {
+			select {
+			case <-hdb.tg.StopChan():
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		start := time.Now()
+		err := rhp.WithTransportV2(ctx, host.NetAddress, host.PublicKey, func(t *rhpv2.Transport) error {
+			_, err := rhp.RPCSettings(ctx, t)
+			return err
+		})
+		return time.Since(start), err
+	}
+
+	baseline, err := fetch()
+	if err != nil {
+		return StressResult{}, err
+	}
+
+	result := StressResult{
+		Concurrency:     concurrency,
+		BaselineLatency: baseline,
+	}
+	var total time.Duration
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			latency, err := fetch()
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				result.Failures++
+				return
+			}
+			result.Successes++
+			total += latency
+			if latency > result.MaxLatency {
+				result.MaxLatency = latency
+			}
+		}()
+	}
+	wg.Wait()
+
+	if result.Successes > 0 {
+		result.AverageLatency = total / time.Duration(result.Successes)
+		if baseline > 0 {
+			result.Degradation = float64(result.AverageLatency) / float64(baseline)
+		}
+	}
+
+	return result, nil
+}
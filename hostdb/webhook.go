@@ -0,0 +1,94 @@
+package hostdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/mike76-dev/hostscore/internal/utils"
+)
+
+const (
+	defaultWebhookTimeout    = 5 * time.Second
+	defaultWebhookMaxRetries = 3
+	webhookInitialBackoff    = time.Second
+)
+
+// webhookPublisher is a ResultPublisher that posts the scan events
+// as JSON to an HTTP endpoint.
+type webhookPublisher struct {
+	url        string
+	client     *http.Client
+	maxRetries int
+}
+
+// NewWebhookPublisher returns a ResultPublisher that posts the scan
+// events to the given URL. A failed request is retried up to maxRetries
+// times with an exponential backoff, as long as the context allows.
+// A zero timeout means the default. A zero maxRetries means the default,
+// and a negative one disables the retries.
+func NewWebhookPublisher(url string, timeout time.Duration, maxRetries int) ResultPublisher {
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+	if maxRetries == 0 {
+		maxRetries = defaultWebhookMaxRetries
+	} else if maxRetries < 0 {
+		maxRetries = 0
+	}
+	return &webhookPublisher{
+		url:        url,
+		client:     &http.Client{Timeout: timeout},
+		maxRetries: maxRetries,
+	}
+}
+
+// Publish implements ResultPublisher.
+func (wp *webhookPublisher) Publish(ctx context.Context, event ScanEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return utils.AddContext(err, "couldn't marshal scan event")
+	}
+
+	backoff := webhookInitialBackoff
+	for attempt := 0; ; attempt++ {
+		err = wp.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if attempt >= wp.maxRetries {
+			return utils.AddContext(err, "webhook request failed")
+		}
+		select {
+		case <-ctx.Done():
+			return utils.AddContext(err, "webhook request failed")
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post sends a single request to the webhook.
+func (wp *webhookPublisher) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wp.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := wp.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
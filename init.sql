@@ -35,6 +35,14 @@ CREATE TABLE wt_locked (
 /* hostdb */
 DROP TABLE IF EXISTS hdb_domains;
 DROP TABLE IF EXISTS hdb_tip;
+DROP TABLE IF EXISTS hdb_removed_mainnet;
+DROP TABLE IF EXISTS hdb_removed_zen;
+DROP TABLE IF EXISTS hdb_notes_mainnet;
+DROP TABLE IF EXISTS hdb_notes_zen;
+DROP TABLE IF EXISTS hdb_rollups_mainnet;
+DROP TABLE IF EXISTS hdb_rollups_zen;
+DROP TABLE IF EXISTS hdb_ip_changes_mainnet;
+DROP TABLE IF EXISTS hdb_ip_changes_zen;
 DROP TABLE IF EXISTS hdb_scans_mainnet;
 DROP TABLE IF EXISTS hdb_benchmarks_mainnet;
 DROP TABLE IF EXISTS hdb_hosts_mainnet;
@@ -62,9 +70,21 @@ CREATE TABLE hdb_hosts_mainnet (
 	revision       BLOB,
 	settings       BLOB,
 	price_table    BLOB,
+	scan_interval  BIGINT NOT NULL DEFAULT 0,
+	priority       INT NOT NULL DEFAULT 0,
+	favorite       BOOL NOT NULL DEFAULT FALSE,
+	previous_keys  VARCHAR(4096) NOT NULL DEFAULT '',
+	accepting_contracts BOOL NOT NULL DEFAULT FALSE,
+	remaining_storage   BIGINT UNSIGNED NOT NULL DEFAULT 0,
+	storage_price       DOUBLE NOT NULL DEFAULT 0,
+	max_duration        BIGINT UNSIGNED NOT NULL DEFAULT 0,
+	benchmarked_at      BIGINT NOT NULL DEFAULT 0,
+	upload_speed        DOUBLE NOT NULL DEFAULT 0,
+	download_speed      DOUBLE NOT NULL DEFAULT 0,
 	modified       BIGINT NOT NULL,
 	fetched        BIGINT NOT NULL,
-	PRIMARY KEY (id)
+	PRIMARY KEY (id),
+	INDEX idx_favorite (favorite)
 );
 
 CREATE TABLE hdb_scans_mainnet (
@@ -76,6 +96,13 @@ CREATE TABLE hdb_scans_mainnet (
 	error        TEXT NOT NULL,
 	settings     BLOB,
 	price_table  BLOB,
+	region       VARCHAR(64) NOT NULL DEFAULT '',
+	rhp3_ttfb    DOUBLE NOT NULL DEFAULT 0,
+	price_table_fetch DOUBLE NOT NULL DEFAULT 0,
+	warnings     VARCHAR(1024) NOT NULL DEFAULT '',
+	siamux_port  VARCHAR(8) NOT NULL DEFAULT '',
+	signature    BLOB,
+	ever_online  BOOL NOT NULL DEFAULT FALSE,
 	modified     BIGINT NOT NULL,
 	fetched      BIGINT NOT NULL,
 	PRIMARY KEY (id),
@@ -91,6 +118,9 @@ CREATE TABLE hdb_benchmarks_mainnet (
 	download_speed DOUBLE NOT NULL,
 	ttfb           DOUBLE NOT NULL,
 	error          TEXT NOT NULL,
+	error_category VARCHAR(16) NOT NULL DEFAULT '',
+	partial        BOOL NOT NULL DEFAULT FALSE,
+	concurrency    INT NOT NULL DEFAULT 1,
 	modified       BIGINT NOT NULL,
 	fetched        BIGINT NOT NULL,
 	PRIMARY KEY (id),
@@ -117,9 +147,21 @@ CREATE TABLE hdb_hosts_zen (
 	revision       BLOB,
 	settings       BLOB,
 	price_table    BLOB,
+	scan_interval  BIGINT NOT NULL DEFAULT 0,
+	priority       INT NOT NULL DEFAULT 0,
+	favorite       BOOL NOT NULL DEFAULT FALSE,
+	previous_keys  VARCHAR(4096) NOT NULL DEFAULT '',
+	accepting_contracts BOOL NOT NULL DEFAULT FALSE,
+	remaining_storage   BIGINT UNSIGNED NOT NULL DEFAULT 0,
+	storage_price       DOUBLE NOT NULL DEFAULT 0,
+	max_duration        BIGINT UNSIGNED NOT NULL DEFAULT 0,
+	benchmarked_at      BIGINT NOT NULL DEFAULT 0,
+	upload_speed        DOUBLE NOT NULL DEFAULT 0,
+	download_speed      DOUBLE NOT NULL DEFAULT 0,
 	modified       BIGINT NOT NULL,
 	fetched        BIGINT NOT NULL,
-	PRIMARY KEY (id)
+	PRIMARY KEY (id),
+	INDEX idx_favorite (favorite)
 );
 
 CREATE TABLE hdb_scans_zen (
@@ -131,6 +173,13 @@ CREATE TABLE hdb_scans_zen (
 	error        TEXT NOT NULL,
 	settings     BLOB,
 	price_table  BLOB,
+	region       VARCHAR(64) NOT NULL DEFAULT '',
+	rhp3_ttfb    DOUBLE NOT NULL DEFAULT 0,
+	price_table_fetch DOUBLE NOT NULL DEFAULT 0,
+	warnings     VARCHAR(1024) NOT NULL DEFAULT '',
+	siamux_port  VARCHAR(8) NOT NULL DEFAULT '',
+	signature    BLOB,
+	ever_online  BOOL NOT NULL DEFAULT FALSE,
 	modified     BIGINT NOT NULL,
 	fetched      BIGINT NOT NULL,
 	PRIMARY KEY (id),
@@ -146,12 +195,95 @@ CREATE TABLE hdb_benchmarks_zen (
 	download_speed DOUBLE NOT NULL,
 	ttfb           DOUBLE NOT NULL,
 	error          TEXT NOT NULL,
+	error_category VARCHAR(16) NOT NULL DEFAULT '',
+	partial        BOOL NOT NULL DEFAULT FALSE,
+	concurrency    INT NOT NULL DEFAULT 1,
 	modified       BIGINT NOT NULL,
 	fetched        BIGINT NOT NULL,
 	PRIMARY KEY (id),
 	FOREIGN KEY (public_key) REFERENCES hdb_hosts_zen(public_key)
 );
 
+CREATE TABLE hdb_ip_changes_mainnet (
+	id          BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	public_key  BINARY(32) NOT NULL,
+	changed_at  BIGINT NOT NULL,
+	net_address VARCHAR(255) NOT NULL,
+	ip_nets     TEXT NOT NULL,
+	PRIMARY KEY (id),
+	FOREIGN KEY (public_key) REFERENCES hdb_hosts_mainnet(public_key)
+);
+
+CREATE TABLE hdb_ip_changes_zen (
+	id          BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	public_key  BINARY(32) NOT NULL,
+	changed_at  BIGINT NOT NULL,
+	net_address VARCHAR(255) NOT NULL,
+	ip_nets     TEXT NOT NULL,
+	PRIMARY KEY (id),
+	FOREIGN KEY (public_key) REFERENCES hdb_hosts_zen(public_key)
+);
+
+CREATE TABLE hdb_notes_mainnet (
+	id          BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	public_key  BINARY(32) NOT NULL,
+	created_at  BIGINT NOT NULL,
+	note        TEXT NOT NULL,
+	PRIMARY KEY (id),
+	FOREIGN KEY (public_key) REFERENCES hdb_hosts_mainnet(public_key)
+);
+
+CREATE TABLE hdb_notes_zen (
+	id          BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	public_key  BINARY(32) NOT NULL,
+	created_at  BIGINT NOT NULL,
+	note        TEXT NOT NULL,
+	PRIMARY KEY (id),
+	FOREIGN KEY (public_key) REFERENCES hdb_hosts_zen(public_key)
+);
+
+CREATE TABLE hdb_rollups_mainnet (
+	id          BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	public_key  BINARY(32) NOT NULL,
+	day         BIGINT NOT NULL,
+	successes   INT NOT NULL,
+	failures    INT NOT NULL,
+	min_latency DOUBLE NOT NULL,
+	avg_latency DOUBLE NOT NULL,
+	max_latency DOUBLE NOT NULL,
+	settings    BLOB,
+	PRIMARY KEY (id),
+	INDEX idx_public_key_day (public_key, day),
+	FOREIGN KEY (public_key) REFERENCES hdb_hosts_mainnet(public_key)
+);
+
+CREATE TABLE hdb_rollups_zen (
+	id          BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	public_key  BINARY(32) NOT NULL,
+	day         BIGINT NOT NULL,
+	successes   INT NOT NULL,
+	failures    INT NOT NULL,
+	min_latency DOUBLE NOT NULL,
+	avg_latency DOUBLE NOT NULL,
+	max_latency DOUBLE NOT NULL,
+	settings    BLOB,
+	PRIMARY KEY (id),
+	INDEX idx_public_key_day (public_key, day),
+	FOREIGN KEY (public_key) REFERENCES hdb_hosts_zen(public_key)
+);
+
+CREATE TABLE hdb_removed_mainnet (
+	public_key  BINARY(32) NOT NULL,
+	removed_at  BIGINT NOT NULL,
+	PRIMARY KEY (public_key)
+);
+
+CREATE TABLE hdb_removed_zen (
+	public_key  BINARY(32) NOT NULL,
+	removed_at  BIGINT NOT NULL,
+	PRIMARY KEY (public_key)
+);
+
 CREATE TABLE hdb_tip (
 	id               INT NOT NULL,
 	network VARCHAR(8) NOT NULL,
@@ -64,6 +64,7 @@ CREATE TABLE scans (
 	id           BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
 	network      VARCHAR(8) NOT NULL,
 	node         VARCHAR(8) NOT NULL,
+	region       VARCHAR(64) NOT NULL DEFAULT '',
 	public_key   BINARY(32) NOT NULL,
 	ran_at       BIGINT NOT NULL,
 	success      BOOL NOT NULL,
@@ -116,6 +117,9 @@ CREATE TABLE locations (
 	isp        TEXT NOT NULL,
 	zip        TEXT NOT NULL,
 	time_zone  TEXT NOT NULL,
+	lat        DOUBLE,
+	lon        DOUBLE,
 	fetched_at BIGINT NOT NULL,
-	PRIMARY KEY (network, public_key)
+	PRIMARY KEY (network, public_key),
+	INDEX idx_locations_lat_lon (lat, lon)
 );
@@ -55,5 +55,5 @@ func AddContext(err error, ctx string) error {
 	if err == nil {
 		return nil
 	}
-	return fmt.Errorf("%s: %s", ctx, err.Error())
+	return fmt.Errorf("%s: %w", ctx, err)
 }
@@ -169,6 +169,24 @@ func LookupIPNets(addr string) (ipNets []string, err error) {
 	return
 }
 
+// IsPrivateIPNets returns true if all of the provided subnets are
+// private, loopback, link-local, or unspecified.
+func IsPrivateIPNets(ipNets []string) bool {
+	if len(ipNets) == 0 {
+		return false
+	}
+	for _, subnet := range ipNets {
+		ip, _, err := net.ParseCIDR(subnet)
+		if err != nil {
+			return false
+		}
+		if !ip.IsPrivate() && !ip.IsLoopback() && !ip.IsLinkLocalUnicast() && !ip.IsUnspecified() {
+			return false
+		}
+	}
+	return true
+}
+
 // EqualIPNets checks if two slices of IP subnets contain the same subnets.
 func EqualIPNets(ipNetsA, ipNetsB []string) bool {
 	// Check the length first.
@@ -17,12 +17,112 @@ const configFilename = "hsdconfig.json"
 
 // HSDConfig contains the fields that are passed on to the new node.
 type HSDConfig struct {
-	GatewayMainnet string `json:"gatewayMainnet"`
-	GatewayZen     string `json:"gatewayZen"`
-	APIAddr        string `json:"api"`
-	Dir            string `json:"dir"`
-	DBUser         string `json:"dbUser"`
-	DBName         string `json:"dbName"`
+	GatewayMainnet string       `json:"gatewayMainnet"`
+	GatewayZen     string       `json:"gatewayZen"`
+	APIAddr        string       `json:"api"`
+	Dir            string       `json:"dir"`
+	DBUser         string       `json:"dbUser"`
+	DBName         string       `json:"dbName"`
+	HostDB         HostDBConfig `json:"hostdb"`
+}
+
+// HostDBConfig contains the settings of the HostDB.
+type HostDBConfig struct {
+	// Region identifies the location of the scanner. It is recorded
+	// with each scan, so that the measurements taken by different
+	// scanners can be told apart.
+	Region string `json:"region"`
+
+	// ScanTimeoutPercentile and ScanTimeoutMultiplier define the scan
+	// timeout, which is calculated from the latencies of the initial
+	// scans: the latency at the given percentile (between 0 and 1)
+	// is multiplied by the multiplier. The timeout is never set below
+	// 30 seconds.
+	ScanTimeoutPercentile float64 `json:"scanTimeoutPercentile"`
+	ScanTimeoutMultiplier float64 `json:"scanTimeoutMultiplier"`
+
+	// ScanPrivateAddresses makes the scanner connect to the hosts that
+	// resolve only to private or loopback addresses. By default, such
+	// hosts are flagged and treated as unreachable.
+	ScanPrivateAddresses bool `json:"scanPrivateAddresses"`
+
+	// IPChangeScanMultiplier shortens the scan interval of the hosts
+	// whose IP address has changed within the last 24 hours. It must be
+	// between 0 and 1.
+	IPChangeScanMultiplier float64 `json:"ipChangeScanMultiplier"`
+
+	// BenchmarkTimeout is the maximum time in seconds a single host can
+	// spend running a benchmark. The default is 5 minutes.
+	BenchmarkTimeout int `json:"benchmarkTimeout"`
+
+	// SyncWaitInterval is the maximum interval in seconds between the
+	// checks whether the blockchain is synced before the scanning starts.
+	// The default is 1 minute.
+	SyncWaitInterval int `json:"syncWaitInterval"`
+
+	// DisableAddressReuse makes the scanner resolve the SiaMux address
+	// of a host separately instead of reusing the IP address the RHP2
+	// connection was made to.
+	DisableAddressReuse bool `json:"disableAddressReuse"`
+
+	// ScanWhenUnsynced lets the scanning start before the blockchain is
+	// synced, as soon as the node has a peer and a non-zero tip. This
+	// is useful if only the reachability of the hosts matters, but the
+	// host set may be incomplete or stale until the sync completes.
+	// Benchmarks are still only run when synced.
+	ScanWhenUnsynced bool `json:"scanWhenUnsynced"`
+
+	// SignScans makes the scanner sign each scan with the wallet key
+	// of the network, so that the consumers of the scans can verify
+	// where they came from.
+	SignScans bool `json:"signScans"`
+
+	// GroupScansBySubnet orders the hosts due for a scan by their
+	// subnet, so that the hosts sharing a subnet are scanned one after
+	// another. This improves the reuse of a DNS cache and of the
+	// network path. The number of subnet switches is reported in the
+	// scanner metrics.
+	GroupScansBySubnet bool `json:"groupScansBySubnet"`
+
+	// AnnouncementWorkers is the number of the host addresses announced
+	// in a block that are resolved concurrently. The default is 8.
+	AnnouncementWorkers int `json:"announcementWorkers"`
+
+	// ConcurrentBenchmarks is the number of benchmarks that may measure
+	// the throughput at the same time. The default is 1, which gives
+	// the most accurate results. With a higher number, the benchmarks
+	// compete for the bandwidth of the node and measure lower speeds;
+	// the number of the benchmarks that ran concurrently is recorded
+	// with each benchmark, so that such results can be filtered out.
+	ConcurrentBenchmarks int `json:"concurrentBenchmarks"`
+
+	// SuccessfulScanRetention and FailedScanRetention are the numbers
+	// of days the successful and the failed scans are kept for.
+	// The default is 7 days for both.
+	SuccessfulScanRetention int `json:"successfulScanRetention"`
+	FailedScanRetention     int `json:"failedScanRetention"`
+
+	// Webhook configures an HTTP endpoint the scan results are posted to.
+	Webhook WebhookConfig `json:"webhook"`
+}
+
+// WebhookConfig contains the settings of the scan result webhook.
+// Zero values mean the defaults.
+type WebhookConfig struct {
+	// URL is the address the scan results are posted to. The webhook
+	// is disabled if the URL is empty.
+	URL string `json:"url"`
+
+	// Timeout is the timeout of a single request in seconds.
+	Timeout int `json:"timeout"`
+
+	// MaxRetries is how many times a failed request is retried.
+	// A negative value disables the retries.
+	MaxRetries int `json:"maxRetries"`
+
+	// QueueSize is the number of scan results that can wait for
+	// delivery before new results are dropped.
+	QueueSize int `json:"queueSize"`
 }
 
 // hsdMetadata contains the header and version strings that identify the
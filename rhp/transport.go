@@ -2,11 +2,20 @@ package rhp
 
 import (
 	"context"
+	"errors"
+	"io"
 	"net"
 
 	rhpv2 "go.sia.tech/core/rhp/v2"
 	rhpv3 "go.sia.tech/core/rhp/v3"
 	"go.sia.tech/core/types"
+	"golang.org/x/crypto/curve25519"
+	"lukechampine.com/frand"
+)
+
+var (
+	loopEnter              = types.NewSpecifier("LoopEnter")
+	cipherChaCha20Poly1305 = types.NewSpecifier("ChaCha20Poly1305")
 )
 
 // dial is a helper function, which connects to the specified address.
@@ -21,6 +30,26 @@ func WithTransportV2(ctx context.Context, hostIP string, hostKey types.PublicKey
 	if err != nil {
 		return err
 	}
+	return withConnV2(ctx, conn, hostKey, fn)
+}
+
+// WithTransportV2Remote is like WithTransportV2, but it also returns
+// the IP address the connection was made to, so that the subsequent
+// connections to the same host can skip the name resolution.
+func WithTransportV2Remote(ctx context.Context, hostIP string, hostKey types.PublicKey, fn func(*rhpv2.Transport) error) (remoteIP string, err error) {
+	conn, err := dial(ctx, hostIP)
+	if err != nil {
+		return "", err
+	}
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		remoteIP = addr.IP.String()
+	}
+	return remoteIP, withConnV2(ctx, conn, hostKey, fn)
+}
+
+// withConnV2 performs the RHP2 handshake over the connection and calls
+// the RPC.
+func withConnV2(ctx context.Context, conn net.Conn, hostKey types.PublicKey, fn func(*rhpv2.Transport) error) (err error) {
 	done := make(chan struct{})
 	go func() {
 		select {
@@ -43,6 +72,55 @@ func WithTransportV2(ctx context.Context, hostIP string, hostKey types.PublicKey
 	return fn(t)
 }
 
+// HandshakeV2 starts the RHP2 handshake and reads the host's response
+// without verifying its signature, so the host's public key needn't be
+// known. An error is returned if the host doesn't respond with a
+// supported cipher.
+func HandshakeV2(ctx context.Context, hostIP string) (err error) {
+	conn, err := dial(ctx, hostIP)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			conn.Close()
+		}
+	}()
+	defer func() {
+		close(done)
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}()
+
+	xpk, _ := curve25519.X25519(frand.Bytes(32), curve25519.Basepoint)
+	e := types.NewEncoder(conn)
+	loopEnter.EncodeTo(e)
+	e.Write(xpk)
+	types.EncodeSlice(e, []types.Specifier{cipherChaCha20Poly1305})
+	if err := e.Flush(); err != nil {
+		return err
+	}
+
+	var hostXPK [32]byte
+	var cipher types.Specifier
+	d := types.NewDecoder(io.LimitedReader{R: conn, N: 1024})
+	d.Read(hostXPK[:])
+	d.ReadBytes() // signature
+	cipher.DecodeFrom(d)
+	if err := d.Err(); err != nil {
+		return err
+	}
+	if cipher != cipherChaCha20Poly1305 {
+		return errors.New("host selected unsupported cipher")
+	}
+	return nil
+}
+
 // WithTransportV3 creates a transport and calls an RHP3 RPC.
 func WithTransportV3(ctx context.Context, siamuxAddr string, hostKey types.PublicKey, fn func(*rhpv3.Transport) error) (err error) {
 	conn, err := dial(ctx, siamuxAddr)